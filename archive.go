@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Header ID of the zip64 extended information extra field
+const zip64ExtraID = 0x0001
+
+// Pick files from ?files=0,2,5 (or repeated ?files=), defaulting to all of them
+func selectTorrentFiles(t *torrent.Torrent, r *http.Request) ([]*torrent.File, error) {
+	allFiles := t.Files()
+
+	var indexes []string
+	for _, value := range r.URL.Query()["files"] {
+		for _, index := range strings.Split(value, ",") {
+			if index = strings.TrimSpace(index); index != "" {
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		return allFiles, nil
+	}
+
+	seen := make(map[int]bool)
+	var selected []*torrent.File
+	for _, indexString := range indexes {
+		index, err := strconv.Atoi(indexString)
+		if err != nil || index < 0 || index >= len(allFiles) {
+			return nil, fmt.Errorf("invalid file index: %s", indexString)
+		}
+		if !seen[index] {
+			seen[index] = true
+			selected = append(selected, allFiles[index])
+		}
+	}
+	return selected, nil
+}
+
+// Download files from a session as a zip or tar archive (?format=zip|tar).
+// A single selected file is sent as-is so download managers can resume it
+// with Range requests; archives are streamed and cannot be resumed.
+func serveArchive(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	files, err := selectTorrentFiles(session.Torrent, r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if len(files) == 1 {
+		file := files[0]
+		reader := file.NewReader()
+		defer reader.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(file.DisplayPath()),
+		}))
+		http.ServeContent(w, r, file.DisplayPath(), time.Time{}, reader)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Unsupported archive format: " + format})
+		return
+	}
+
+	if format == "tar" {
+		w.Header().Set("Content-Type", "application/x-tar")
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": session.Torrent.Name() + "." + format,
+	}))
+	w.Header().Set("Accept-Ranges", "none")
+
+	log.Printf("Streaming %d files from %s as %s", len(files), session.Torrent.Name(), format)
+
+	if format == "tar" {
+		err = writeTarArchive(r.Context(), w, files)
+	} else {
+		err = writeZipArchive(r.Context(), w, files)
+	}
+	if err != nil {
+		// Headers are already sent, so all we can do is stop and log
+		log.Printf("Error streaming archive: %v", err)
+	}
+}
+
+func writeZipArchive(ctx context.Context, w io.Writer, files []*torrent.File) error {
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		err := writeZipEntry(archive, file.Path(), file.Length(), func(entry io.Writer) error {
+			return copyTorrentFile(ctx, entry, file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Add a stored entry of a known size to a zip archive.
+//
+// Sizes aren't known to archive/zip while streaming, so it marks entries
+// over 4 GiB as zip64 in the central directory only. Readers that go by
+// local headers then misread the 64-bit data descriptor, so large entries
+// get a zip64 field in their local header as well.
+func writeZipEntry(archive *zip.Writer, name string, size int64, write func(io.Writer) error) error {
+	// Media is already compressed, so store it as-is to keep streaming cheap
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             zip.Store,
+		Modified:           time.Now(),
+		UncompressedSize64: uint64(size),
+	}
+	var localExtra []byte
+	if size > math.MaxUint32 {
+		localExtra = make([]byte, 20)
+		binary.LittleEndian.PutUint16(localExtra[0:], zip64ExtraID)
+		binary.LittleEndian.PutUint16(localExtra[2:], 16)
+		binary.LittleEndian.PutUint64(localExtra[4:], uint64(size))
+		binary.LittleEndian.PutUint64(localExtra[12:], uint64(size))
+		header.Extra = localExtra
+	}
+
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if err := write(entry); err != nil {
+		return err
+	}
+
+	// The central directory gets its own zip64 field when the archive is
+	// closed, so keep this one out of it
+	header.Extra = header.Extra[len(localExtra):]
+	return nil
+}
+
+func writeTarArchive(ctx context.Context, w io.Writer, files []*torrent.File) error {
+	archive := tar.NewWriter(w)
+	for _, file := range files {
+		err := archive.WriteHeader(&tar.Header{
+			Name:    file.Path(),
+			Mode:    0644,
+			Size:    file.Length(),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyTorrentFile(ctx, archive, file); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Copy a whole torrent file, waiting for pieces that aren't downloaded yet
+func copyTorrentFile(ctx context.Context, w io.Writer, file *torrent.File) error {
+	reader := file.NewReader()
+	defer reader.Close()
+	// Read well ahead, since an archive is consumed sequentially
+	reader.SetReadahead(32 << 20)
+
+	_, err := io.Copy(w, contextReader{ctx, reader})
+	return err
+}
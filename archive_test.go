@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Keeps the start and end of a stream, for archives too big to hold
+type headTailWriter struct {
+	head, tail []byte
+	size       int64
+	keep       int
+}
+
+func (w *headTailWriter) Write(p []byte) (int, error) {
+	if room := w.keep - len(w.head); room > 0 {
+		w.head = append(w.head, p[:min(room, len(p))]...)
+	}
+	w.tail = append(w.tail, p[max(0, len(p)-w.keep):]...)
+	w.tail = w.tail[max(0, len(w.tail)-w.keep):]
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// Reads the kept start and end, and zeros in between
+func (w *headTailWriter) ReadAt(p []byte, off int64) (int, error) {
+	tailStart := w.size - int64(len(w.tail))
+	for i := range p {
+		pos := off + int64(i)
+		switch {
+		case pos >= w.size:
+			return i, io.EOF
+		case pos < int64(len(w.head)):
+			p[i] = w.head[pos]
+		case pos >= tailStart:
+			p[i] = w.tail[pos-tailStart]
+		default:
+			p[i] = 0
+		}
+	}
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// Extra field IDs in a local file header at the start of data
+func localHeaderExtraIDs(t *testing.T, data []byte) []uint16 {
+	t.Helper()
+	if binary.LittleEndian.Uint32(data) != 0x04034b50 {
+		t.Fatalf("no local file header")
+	}
+	nameLength := int(binary.LittleEndian.Uint16(data[26:]))
+	extraLength := int(binary.LittleEndian.Uint16(data[28:]))
+	extra := data[30+nameLength : 30+nameLength+extraLength]
+
+	var ids []uint16
+	for len(extra) >= 4 {
+		ids = append(ids, binary.LittleEndian.Uint16(extra))
+		extra = extra[4+int(binary.LittleEndian.Uint16(extra[2:])):]
+	}
+	return ids
+}
+
+func TestWriteZipEntry(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err := writeZipEntry(archive, "video.mkv", 5, func(w io.Writer) error {
+		_, err := io.WriteString(w, "hello")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range localHeaderExtraIDs(t, buf.Bytes()) {
+		if id == zip64ExtraID {
+			t.Errorf("small entry has a zip64 local header field")
+		}
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := reader.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if content, err := io.ReadAll(file); err != nil || string(content) != "hello" {
+		t.Errorf("content = %q, %v; want \"hello\"", content, err)
+	}
+}
+
+func TestWriteZipEntryZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 4 GiB archive")
+	}
+
+	const size = 1<<32 + 10
+	out := &headTailWriter{keep: 1024}
+	archive := zip.NewWriter(out)
+	err := writeZipEntry(archive, "video.mkv", size, func(w io.Writer) error {
+		_, err := io.CopyN(w, zeroReader{}, size)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := localHeaderExtraIDs(t, out.head)
+	if len(ids) == 0 || ids[0] != zip64ExtraID {
+		t.Errorf("local header extra fields = %v, want a zip64 field first", ids)
+	}
+
+	reader, err := zip.NewReader(out, out.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := reader.File[0]
+	if file.UncompressedSize64 != size || file.CompressedSize64 != size {
+		t.Errorf("sizes = %d, %d; want %d", file.UncompressedSize64, file.CompressedSize64, size)
+	}
+
+	// Only one zip64 field in the central directory
+	zip64Fields := 0
+	for extra := file.Extra; len(extra) >= 4; extra = extra[4+int(binary.LittleEndian.Uint16(extra[2:])):] {
+		if binary.LittleEndian.Uint16(extra) == zip64ExtraID {
+			zip64Fields++
+		}
+	}
+	if zip64Fields != 1 {
+		t.Errorf("central directory has %d zip64 fields, want 1", zip64Fields)
+	}
+
+	// The data descriptor after the entry has 64-bit sizes
+	dataOffset, err := file.DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := make([]byte, 24)
+	if _, err := out.ReadAt(descriptor, dataOffset+size); err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(descriptor) != 0x08074b50 || binary.LittleEndian.Uint64(descriptor[16:]) != size {
+		t.Errorf("data descriptor = %x, want 64-bit sizes", descriptor)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long signed stream links stay valid
+const signedURLLifetime = 24 * time.Hour
+
+type CastSettings struct {
+	PublicBaseURL  string   `json:"publicBaseUrl"`
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+// Key for signing stream links, kept out of settings.json since settings
+// are readable through the API
+const streamKeyFile = "config/stream.key"
+
+var (
+	streamKey     []byte
+	streamKeyOnce sync.Once
+)
+
+// Load the stream signing key, creating and saving one on first use
+func streamSigningKey() []byte {
+	streamKeyOnce.Do(func() {
+		if key, err := os.ReadFile(streamKeyFile); err == nil && len(key) > 0 {
+			streamKey = key
+			return
+		}
+
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		streamKey = []byte(hex.EncodeToString(secret))
+		err := os.MkdirAll(filepath.Dir(streamKeyFile), 0755)
+		if err == nil {
+			err = os.WriteFile(streamKeyFile, streamKey, 0600)
+		}
+		if err != nil {
+			log.Printf("Warning: Could not save stream signing key, links will expire on restart: %v", err)
+		}
+	})
+	return streamKey
+}
+
+// Sign a link's path, its query apart from the signature itself, and its
+// expiry, so none of them can be changed without breaking the signature
+func streamSignature(urlPath string, query url.Values, expires int64) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != "sig" && name != "expires" {
+			signed[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, streamSigningKey())
+	// Encode sorts by name, so the order parameters came in doesn't matter
+	fmt.Fprintf(mac, "%s\n%s\n%d", urlPath, signed.Encode(), expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Add an expiry and signature to a server-relative stream link
+func signStreamLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	expires := time.Now().Add(signedURLLifetime).Unix()
+	query := u.Query()
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", streamSignature(u.EscapedPath(), query, expires))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Turn a server-relative stream link into an absolute one that carries an
+// expiry and signature, for players that can't share the browser's session
+func signedStreamURL(r *http.Request, link string) string {
+	return requestBaseURL(r) + signStreamLink(link)
+}
+
+// Check the signature of a signed link, so its path, query and expiry can't
+// be changed. Unsigned links are served as before. Returns false if a
+// response was written.
+func checkStreamSignature(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	signature := query.Get("sig")
+	if signature == "" {
+		return true
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Stream link has expired"})
+		return false
+	}
+	if !hmac.Equal([]byte(signature), []byte(streamSignature(r.URL.EscapedPath(), query, expires))) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Invalid stream signature"})
+		return false
+	}
+	return true
+}
+
+// Everything a cast receiver needs to play a file:
+// GET /api/v1/torrent/[sessionId]/cast/[index]
+func serveCastInfo(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession, parts []string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if len(parts) < 7 {
+		http.Error(w, "Invalid cast path", http.StatusBadRequest)
+		return
+	}
+	files := session.Torrent.Files()
+	fileIndex, err := strconv.Atoi(parts[6])
+	if err != nil || fileIndex < 0 || fileIndex >= len(files) {
+		http.Error(w, "Invalid file index", http.StatusBadRequest)
+		return
+	}
+	if !isVideoFile(files[fileIndex].DisplayPath()) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Only video files can be cast"})
+		return
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.DisplayPath()
+	}
+
+	var subtitles []map[string]interface{}
+	for _, subIndex := range matchSubtitlesToVideos(paths)[fileIndex] {
+		// Cast receivers only understand WebVTT
+		if strings.ToLower(path.Ext(paths[subIndex])) == ".sub" {
+			continue
+		}
+		subtitle := map[string]interface{}{
+			"index":       subIndex,
+			"name":        paths[subIndex],
+			"contentType": "text/vtt",
+			"url":         signedStreamURL(r, fmt.Sprintf("/api/v1/torrent/%s/stream/%d.vtt?format=vtt", sessionID, subIndex)),
+		}
+		if lang, ok := parseSubtitleLanguage(paths[subIndex]); ok {
+			subtitle["language"] = lang.Code
+			subtitle["languageName"] = lang.Name
+		}
+		subtitles = append(subtitles, subtitle)
+	}
+	if subtitles == nil {
+		subtitles = []map[string]interface{}{}
+	}
+
+	info := map[string]interface{}{
+		"title":       fileStem(paths[fileIndex]),
+		"url":         signedStreamURL(r, fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, fileIndex)),
+		"contentType": videoContentType(paths[fileIndex]),
+		"size":        files[fileIndex].Length(),
+		"subtitles":   subtitles,
+		"expiresAt":   time.Now().Add(signedURLLifetime),
+	}
+	if progress, ok := getWatchProgress(session.Torrent.InfoHash().HexString(), fileIndex); ok {
+		info["startTime"] = progress.Position
+	}
+	if _, err := findFFmpeg(); err == nil {
+		info["poster"] = signedStreamURL(r, fmt.Sprintf("/api/v1/torrent/%s/preview/%d/poster.jpg", sessionID, fileIndex))
+	}
+
+	respondWithJSON(w, http.StatusOK, info)
+}
+
+// Cast Settings Save Handler
+func saveCastSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings CastSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(newSettings.PublicBaseURL), "/")
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Public base URL must be an http(s) URL"})
+			return
+		}
+	}
+
+	var trustedProxies []string
+	for _, proxy := range newSettings.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid trusted proxy: " + proxy})
+			return
+		}
+		trustedProxies = append(trustedProxies, proxy)
+	}
+
+	settingsMutex.Lock()
+	currentSettings.PublicBaseURL = baseURL
+	currentSettings.TrustedProxies = trustedProxies
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Cast settings saved successfully"})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sign with a fixed key instead of creating config/stream.key
+func useTestStreamKey() {
+	streamKeyOnce.Do(func() {
+		streamKey = []byte("test key")
+	})
+}
+
+func TestCheckStreamSignature(t *testing.T) {
+	useTestStreamKey()
+
+	signed := signStreamLink("/api/v1/torrent/abc/stream/1.vtt?format=vtt&offset=500")
+	expired := "/api/v1/torrent/abc/stream/1?expires=1&sig=" + streamSignature("/api/v1/torrent/abc/stream/1", nil, 1)
+
+	tests := []struct {
+		name string
+		link string
+		want int
+	}{
+		{"signed", signed, http.StatusOK},
+		{"unsigned", "/api/v1/torrent/abc/stream/1", http.StatusOK},
+		{"changed offset", strings.Replace(signed, "offset=500", "offset=900", 1), http.StatusForbidden},
+		{"added transcode", signed + "&transcode=720p", http.StatusForbidden},
+		{"added audio", signed + "&audio=jpn", http.StatusForbidden},
+		{"other path", strings.Replace(signed, "/stream/1.vtt", "/stream/2.vtt", 1), http.StatusForbidden},
+		{"changed expiry", strings.Replace(signed, "expires=", "expires=9", 1), http.StatusForbidden},
+		{"expired", expired, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			status := http.StatusOK
+			if !checkStreamSignature(w, httptest.NewRequest("GET", tt.link, nil)) {
+				status = w.Code
+			}
+			if status != tt.want {
+				t.Errorf("status = %d, want %d (%s)", status, tt.want, w.Body.String())
+			}
+		})
+	}
+}
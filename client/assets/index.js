@@ -192,46 +192,62 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
       return;
     }
 
-    const subtitleFiles = files.filter((f) =>
-      f.name.match(/\.(srt|vtt|sub)$/i)
-    );
+    // Text track for a subtitle file
+    const subtitleTrack = (subFile) => {
+      let language = "en";
+      let langName = "English";
+
+      // Try to extract language code from filename
+      const langMatch = subFile.name.match(/\.([a-z]{2,3})\.(srt|vtt|sub)$/i);
+      if (subFile.language) {
+        language = subFile.language;
+        langName = subFile.languageName;
+      } else if (langMatch) {
+        language = langMatch[1];
+        langName = getLanguage(language);
+      }
+
+      return {
+        src:
+          "/api/v1/torrent/" +
+          sessionId +
+          "/stream/" +
+          subFile.index +
+          ".vtt?format=vtt",
+        srclang: language,
+        label: langName,
+        kind: "subtitles",
+        type: "vtt",
+      };
+    };
+
+    // Every subtitle in the torrent, for videos the server matched none to
+    const allSubtitles = files
+      .filter((f) => f.name.match(/\.(srt|vtt|sub)$/i))
+      .map(subtitleTrack);
 
+    // Each video carries the subtitles the server matched to it
     const videoUrls = videoFiles.map((file) => {
       return {
         src: "/api/v1/torrent/" + sessionId + "/stream/" + file.index,
         title: file.name,
-        type: "video/mp4",
+        type: file.contentType || "video/mp4",
+        tracks: file.subtitles?.length
+          ? file.subtitles.map(subtitleTrack)
+          : allSubtitles,
       };
     });
 
-    let subtitles = [];
-    if (subtitleFiles.length) {
-      subtitles = subtitleFiles.map((subFile) => {
-        let language = "en";
-        let langName = "English";
-
-        // Try to extract language code from filename
-        console.log(subFile.name);
-        const langMatch = subFile.name.match(/\.([a-z]{2,3})\.(srt|vtt|sub)$/i);
-        if (langMatch) {
-          language = langMatch[1];
-          langName = getLanguage(language);
-        }
+    // Switch to another video along with its subtitles
+    const playVideo = (video) => {
+      player.src({ src: video.src, type: video.type });
+      Array.from(player.remoteTextTracks()).forEach((track) =>
+        player.removeRemoteTextTrack(track)
+      );
+      video.tracks.forEach((track) => player.addRemoteTextTrack(track, false));
+      player.play();
+    };
 
-        return {
-          src:
-            "/api/v1/torrent/" +
-            sessionId +
-            "/stream/" +
-            subFile.index +
-            ".vtt?format=vtt",
-          srclang: language,
-          label: langName,
-          kind: "subtitles",
-          type: "vtt",
-        };
-      });
-    }
     player = videojs(
       "video-player",
       {
@@ -244,7 +260,7 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
           type: videoUrls[0].type,
           label: videoUrls[0].title,
         }],
-        tracks: subtitles,
+        tracks: videoUrls[0].tracks,
         html5: {
           nativeTextTracks: false
         },
@@ -259,6 +275,46 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
       },
       function () {
         player = this;
+
+        // Resume where we left off and report the position back every few seconds
+        const currentVideo = () =>
+          videoFiles.find((f) =>
+            player.currentSrc().endsWith("/stream/" + f.index)
+          ) || videoFiles[0];
+        let lastProgressPost = 0;
+        player.on("loadedmetadata", () => {
+          const progress = currentVideo().progress;
+          if (progress && progress.position < player.duration() - 30) {
+            player.currentTime(progress.position);
+          }
+        });
+        player.on("timeupdate", () => {
+          if (Date.now() - lastProgressPost < 10000) return;
+          lastProgressPost = Date.now();
+          const video = currentVideo();
+          video.progress = {
+            position: player.currentTime(),
+            duration: player.duration(),
+          };
+          fetch(
+            "/api/v1/torrent/" + sessionId + "/progress/" + video.index,
+            {
+              method: "POST",
+              headers: { "Content-Type": "application/json" },
+              body: JSON.stringify(video.progress),
+            }
+          ).catch((err) => console.error(err));
+        });
+
+        // Autoplay the next episode when one ends
+        player.on("ended", () => {
+          const next = videoUrls[videoFiles.findIndex((f) => f.index === currentVideo().next)];
+          if (!next) return;
+          const videoSelect = document.querySelector("#video-select");
+          if (videoSelect) videoSelect.value = next.src;
+          playVideo(next);
+        });
+
         player.on("error", (e) => {
           console.error(e);
           butterup.toast({
@@ -293,12 +349,7 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
           videoSelect.appendChild(option);
         });
         videoSelect.addEventListener("change", (e) => {
-          const selectedSrc = e.target.value;
-          player.src({
-            src: selectedSrc,
-            type: "video/mp4",
-          });
-          player.play();
+          playVideo(videoUrls.find((video) => video.src === e.target.value));
         });
         document.querySelector("#video-player").appendChild(videoSelect);
       }
@@ -428,13 +479,28 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
     const results = searchData.slice(start, end);
     results.forEach((result) => {
       const resultDiv = document.createElement("tr");
+      const release = result.release || {};
+      const badges = [
+        result.active && "Playing",
+        result.inHistory && !result.active && "Watched",
+        release.resolution,
+        release.source,
+        release.codec,
+        release.hdr,
+        release.audio,
+      ]
+        .filter(Boolean)
+        .map((badge) => `<span class="release-badge">${badge}</span>`)
+        .join("");
       resultDiv.innerHTML = `
-        <td>${result.title}</td>
+        <td>${result.title}${badges}</td>
         <td>${result.indexer}</td>
         <td>${result.size}</td>
-        <td>${result.leechers}/${result.seeders}</td>
+        <td><span>${result.leechers}/${result.seeders}</span><button id="verify-torrent" type="button" class="release-badge" title="Check live seeders" data-magnet="${
+          result.magnetUrl || ""
+        }" data-download="${result.downloadUrl || ""}">Check</button></td>
         <td><button id="play-torrent" type="button" class="btn small" data-magnet="${
-          result.downloadUrl || result.magnetUrl
+          result.magnetUrl || result.downloadUrl
         }">Watch</button></td>
       `;
       searchResults.querySelector("tbody").appendChild(resultDiv);
@@ -450,6 +516,41 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
       "#search-pagination"
     );
 
+    // Ask trackers and the DHT for the live swarm size
+    searchResults.querySelectorAll("#verify-torrent").forEach((el) => {
+      el.addEventListener("click", async (e) => {
+        const button = e.target;
+        const params = new URLSearchParams();
+        if (button.getAttribute("data-magnet")) {
+          params.set("magnet", button.getAttribute("data-magnet"));
+        } else {
+          params.set("downloadUrl", button.getAttribute("data-download"));
+        }
+        button.setAttribute("disabled", "disabled");
+        button.innerHTML = "Checking...";
+
+        try {
+          const res = await fetch(`/api/v1/search/verify?${params}`);
+          const data = await res.json();
+          if (!res.ok) {
+            throw new Error(data.error || "Failed to check torrent");
+          }
+          button.previousElementSibling.innerHTML = `${data.leechers}/${data.seeders}`;
+          button.innerHTML = "Live";
+        } catch (error) {
+          butterup.toast({
+            message: error.message || "Failed to check torrent",
+            location: "top-right",
+            icon: true,
+            dismissable: true,
+            type: "error",
+          });
+          button.removeAttribute("disabled");
+          button.innerHTML = "Check";
+        }
+      });
+    });
+
     // Add event listener to each play button
     searchResults.querySelectorAll("#play-torrent").forEach((el) => {
       el.addEventListener("click", async (e) => {
@@ -492,35 +593,63 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
     searchResults.classList.add("hidden");
     document.querySelector("#search-pagination").classList.add("hidden");
 
-    let apiUrl = "/api/v1/prowlarr/search";
-
-    if (
-      (!settings.prowlarrHost || !settings.prowlarrApiKey) &&
-      settings.jackettHost &&
-      settings.jackettApiKey
-    ) {
-      apiUrl = "/api/v1/jackett/search";
+    // Search every enabled indexer, showing results as each tracker answers.
+    // The table pages through results itself, so ask for all of them.
+    const params = new URLSearchParams({
+      q: query,
+      format: "ndjson",
+      limit: "0",
+    });
+    const category = document.querySelector("#search-category").value;
+    if (category) {
+      params.set("category", category);
     }
-
-    fetch(`${apiUrl}?q=${query}`, {
+    const handleSearchEvent = ({ event, data }) => {
+      if (event === "results") {
+        searchData = searchData
+          .concat(data.results)
+          .sort((a, b) => b.seeders - a.seeders);
+        updateSearchResults();
+      } else if (event === "error") {
+        // Mention backends that failed while others still returned results
+        butterup.toast({
+          message: `${data.backend}: ${data.error}`,
+          location: "top-right",
+          icon: true,
+          dismissable: true,
+          type: "error",
+        });
+      } else if (event === "done") {
+        // The merged results keep the best-seeded copy of each release
+        searchData = Array.isArray(data?.results) ? data.results : [];
+        updateSearchResults();
+      }
+    };
+    fetch(`/api/v1/search/stream?${params}`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
     })
       .then(async (res) => {
         if (!res.ok) {
           const err = await res.json();
-          throw new Error(res.error || "Failed to fetch search results");
-        }
-        return res.json();
-      })
-      .then((data) => {
-        if (data && typeof data === "object") {
-          searchData = data;
-        } else {
-          searchData = [];
+          throw new Error(err.error || "Failed to fetch search results");
         }
 
-        updateSearchResults();
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffered = "";
+        for (;;) {
+          const { done, value } = await reader.read();
+          if (done) {
+            break;
+          }
+          buffered += decoder.decode(value, { stream: true });
+          const lines = buffered.split("\n");
+          buffered = lines.pop();
+          lines
+            .filter(Boolean)
+            .forEach((line) => handleSearchEvent(JSON.parse(line)));
+        }
       })
       .catch((error) => {
         console.error("There was a problem with the fetch operation:", error);
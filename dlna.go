@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UPnP device and service types we advertise
+const (
+	mediaServerType       = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+const (
+	ssdpAddress      = "239.255.255.250:1900"
+	ssdpMaxAge       = 1800
+	ssdpServerHeader = "Linux/1.0 UPnP/1.0 bitplay/1.0"
+	defaultDLNAName  = "BitPlay"
+	// Pause after a failed SSDP read
+	ssdpReadRetryDelay = time.Second
+)
+
+type DLNASettings struct {
+	EnableDLNA bool   `json:"enableDLNA"`
+	DLNAName   string `json:"dlnaName"`
+}
+
+// Advertises the media server over SSDP while DLNA is enabled
+type ssdpAdvertiser struct {
+	uuid string
+	conn *net.UDPConn
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+var (
+	dlnaAdvertiser *ssdpAdvertiser
+	dlnaMutex      sync.Mutex
+)
+
+// Stable device UUID, so TVs recognise us again after a restart
+func dlnaDeviceUUID() string {
+	hostname, _ := os.Hostname()
+	sum := md5.Sum([]byte("bitplay:" + hostname))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func dlnaEnabled() bool {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings.EnableDLNA
+}
+
+func dlnaFriendlyName() string {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	if currentSettings.DLNAName != "" {
+		return currentSettings.DLNAName
+	}
+	return defaultDLNAName
+}
+
+// Start or stop SSDP advertising to match the current settings
+func restartDLNA() {
+	dlnaMutex.Lock()
+	defer dlnaMutex.Unlock()
+
+	if dlnaAdvertiser != nil {
+		dlnaAdvertiser.close()
+		dlnaAdvertiser = nil
+	}
+	if !dlnaEnabled() {
+		return
+	}
+
+	advertiser, err := startSSDP(dlnaDeviceUUID())
+	if err != nil {
+		log.Printf("Error starting DLNA discovery: %v", err)
+		return
+	}
+	dlnaAdvertiser = advertiser
+	log.Printf("DLNA media server %q advertised on %s", dlnaFriendlyName(), ssdpAddress)
+}
+
+func startSSDP(uuid string) (*ssdpAdvertiser, error) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ssdpAdvertiser{uuid: uuid, conn: conn, stop: make(chan struct{})}
+	s.done.Add(2)
+	go s.serve()
+	go s.announce(group)
+	return s, nil
+}
+
+func (s *ssdpAdvertiser) close() {
+	close(s.stop)
+	s.conn.Close()
+	s.done.Wait()
+
+	// Tell control points we're gone, so they drop us right away
+	if group, err := net.ResolveUDPAddr("udp4", ssdpAddress); err == nil {
+		if conn, err := net.DialUDP("udp4", nil, group); err == nil {
+			for _, target := range s.targets() {
+				fmt.Fprintf(conn, "NOTIFY * HTTP/1.1\r\nHOST: %s\r\nNT: %s\r\nNTS: ssdp:byebye\r\nUSN: %s\r\n\r\n",
+					ssdpAddress, target, s.usn(target))
+			}
+			conn.Close()
+		}
+	}
+}
+
+// Notification types we answer to
+func (s *ssdpAdvertiser) targets() []string {
+	return []string{"upnp:rootdevice", "uuid:" + s.uuid, mediaServerType, contentDirectoryType, connectionManagerType}
+}
+
+func (s *ssdpAdvertiser) usn(target string) string {
+	if target == "uuid:"+s.uuid {
+		return target
+	}
+	return "uuid:" + s.uuid + "::" + target
+}
+
+// Answer M-SEARCH requests from control points
+func (s *ssdpAdvertiser) serve() {
+	defer s.done.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Wait before reading again, so a persistent error can't spin
+			log.Printf("SSDP read error: %v", err)
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(ssdpReadRetryDelay):
+				continue
+			}
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || strings.Trim(req.Header.Get("MAN"), `"`) != "ssdp:discover" {
+			continue
+		}
+
+		searchTarget := req.Header.Get("ST")
+		location := dlnaLocation(from)
+		for _, target := range s.targets() {
+			if searchTarget != "ssdp:all" && searchTarget != target {
+				continue
+			}
+			response := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+				ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), location, ssdpServerHeader, target, s.usn(target))
+			s.conn.WriteToUDP([]byte(response), from)
+		}
+	}
+}
+
+// Send ssdp:alive notifications now and before they expire
+func (s *ssdpAdvertiser) announce(group *net.UDPAddr) {
+	defer s.done.Done()
+	ticker := time.NewTicker(ssdpMaxAge / 3 * time.Second)
+	defer ticker.Stop()
+	for {
+		location := dlnaLocation(group)
+		for _, target := range s.targets() {
+			notify := fmt.Sprintf("NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: ssdp:alive\r\nSERVER: %s\r\nUSN: %s\r\n\r\n",
+				ssdpAddress, ssdpMaxAge, location, target, ssdpServerHeader, s.usn(target))
+			s.conn.WriteToUDP([]byte(notify), group)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Device description URL on the interface that routes to addr
+func dlnaLocation(addr *net.UDPAddr) string {
+	host := "127.0.0.1"
+	if conn, err := net.DialUDP("udp4", nil, addr); err == nil {
+		host = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+	return fmt.Sprintf("http://%s:%d/dlna/device.xml", host, serverPort)
+}
+
+// Serve the device description, service descriptions and control endpoints:
+//
+//	/dlna/device.xml
+//	/dlna/ContentDirectory.xml, /dlna/control/ContentDirectory
+//	/dlna/ConnectionManager.xml, /dlna/control/ConnectionManager
+func dlnaHandler(w http.ResponseWriter, r *http.Request) {
+	if !dlnaEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/dlna/device.xml":
+		writeXML(w, fmt.Sprintf(deviceDescription, xmlEscape(dlnaFriendlyName()), dlnaDeviceUUID()))
+	case "/dlna/ContentDirectory.xml":
+		writeXML(w, contentDirectorySCPD)
+	case "/dlna/ConnectionManager.xml":
+		writeXML(w, connectionManagerSCPD)
+	case "/dlna/control/ContentDirectory":
+		contentDirectoryControl(w, r)
+	case "/dlna/control/ConnectionManager":
+		connectionManagerControl(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// SOAP arguments of the actions we implement. Tags without a namespace match any.
+type soapRequest struct {
+	ObjectID       string `xml:"Body>Browse>ObjectID"`
+	BrowseFlag     string `xml:"Body>Browse>BrowseFlag"`
+	StartingIndex  int    `xml:"Body>Browse>StartingIndex"`
+	RequestedCount int    `xml:"Body>Browse>RequestedCount"`
+}
+
+// Action name from a SOAPAction header like "urn:...:ContentDirectory:1#Browse"
+func soapAction(r *http.Request) string {
+	action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	if i := strings.LastIndex(action, "#"); i >= 0 {
+		return action[i+1:]
+	}
+	return action
+}
+
+func contentDirectoryControl(w http.ResponseWriter, r *http.Request) {
+	action := soapAction(r)
+	switch action {
+	case "GetSearchCapabilities":
+		writeSOAPResponse(w, contentDirectoryType, action, [][2]string{{"SearchCaps", ""}})
+	case "GetSortCapabilities":
+		writeSOAPResponse(w, contentDirectoryType, action, [][2]string{{"SortCaps", ""}})
+	case "GetSystemUpdateID":
+		writeSOAPResponse(w, contentDirectoryType, action, [][2]string{{"Id", "0"}})
+	case "Browse":
+		var request soapRequest
+		if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeSOAPFault(w, 402, "Invalid Args")
+			return
+		}
+		objects, ok := browseDLNA(requestBaseURL(r), request.ObjectID, request.BrowseFlag == "BrowseMetadata")
+		if !ok {
+			writeSOAPFault(w, 701, "No such object")
+			return
+		}
+
+		total := len(objects)
+		start := min(max(request.StartingIndex, 0), total)
+		end := total
+		if request.RequestedCount > 0 {
+			end = min(start+request.RequestedCount, total)
+		}
+		writeSOAPResponse(w, contentDirectoryType, action, [][2]string{
+			{"Result", didlLite(objects[start:end])},
+			{"NumberReturned", strconv.Itoa(end - start)},
+			{"TotalMatches", strconv.Itoa(total)},
+			{"UpdateID", "0"},
+		})
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+func connectionManagerControl(w http.ResponseWriter, r *http.Request) {
+	action := soapAction(r)
+	switch action {
+	case "GetProtocolInfo":
+		var source []string
+		for _, contentType := range videoExtensions {
+			source = append(source, "http-get:*:"+contentType+":*")
+		}
+		sort.Strings(source)
+		writeSOAPResponse(w, connectionManagerType, action, [][2]string{
+			{"Source", strings.Join(source, ",")},
+			{"Sink", ""},
+		})
+	case "GetCurrentConnectionIDs":
+		writeSOAPResponse(w, connectionManagerType, action, [][2]string{{"ConnectionIDs", "0"}})
+	case "GetCurrentConnectionInfo":
+		writeSOAPResponse(w, connectionManagerType, action, [][2]string{
+			{"RcsID", "-1"},
+			{"AVTransportID", "-1"},
+			{"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"},
+			{"Direction", "Output"},
+			{"Status", "OK"},
+		})
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+// A container or item in the ContentDirectory
+type dlnaObject struct {
+	ID         string
+	ParentID   string
+	Title      string
+	ChildCount int
+	// Items only
+	URL         string
+	ContentType string
+	Size        int64
+}
+
+// Objects for a Browse request. The root lists active sessions, and each
+// session lists its video files in episode order.
+func browseDLNA(baseURL, objectID string, metadata bool) ([]dlnaObject, bool) {
+	if objectID == "0" {
+		var containers []dlnaObject
+		sessions.Range(func(key, value interface{}) bool {
+			session := value.(*TorrentSession)
+			containers = append(containers, dlnaObject{
+				ID:         key.(string),
+				ParentID:   "0",
+				Title:      session.Torrent.Name(),
+				ChildCount: len(dlnaVideoItems(baseURL, key.(string), session)),
+			})
+			return true
+		})
+		sort.Slice(containers, func(i, j int) bool {
+			return naturalLess(containers[i].Title, containers[j].Title)
+		})
+		if metadata {
+			return []dlnaObject{{ID: "0", ParentID: "-1", Title: dlnaFriendlyName(), ChildCount: len(containers)}}, true
+		}
+		return containers, true
+	}
+
+	sessionID, itemIndex, isItem := strings.Cut(objectID, "/")
+	value, ok := sessions.Load(sessionID)
+	if !ok {
+		return nil, false
+	}
+	session := value.(*TorrentSession)
+	session.LastUsed = time.Now()
+	items := dlnaVideoItems(baseURL, sessionID, session)
+
+	if isItem {
+		for _, item := range items {
+			if item.ID == sessionID+"/"+itemIndex {
+				if !metadata {
+					return nil, true
+				}
+				return []dlnaObject{item}, true
+			}
+		}
+		return nil, false
+	}
+	if metadata {
+		return []dlnaObject{{ID: sessionID, ParentID: "0", Title: session.Torrent.Name(), ChildCount: len(items)}}, true
+	}
+	return items, true
+}
+
+func dlnaVideoItems(baseURL, sessionID string, session *TorrentSession) []dlnaObject {
+	var items []dlnaObject
+	var paths []string
+	for i, file := range session.Torrent.Files() {
+		if !isVideoFile(file.DisplayPath()) {
+			continue
+		}
+		items = append(items, dlnaObject{
+			ID:          fmt.Sprintf("%s/%d", sessionID, i),
+			ParentID:    sessionID,
+			Title:       path.Base(file.DisplayPath()),
+			URL:         baseURL + signStreamLink(fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, i)),
+			ContentType: videoContentType(file.DisplayPath()),
+			Size:        file.Length(),
+		})
+		paths = append(paths, file.DisplayPath())
+	}
+	sort.Sort(episodeOrder{items, paths})
+	return items
+}
+
+// Sorts items alongside their file paths
+type episodeOrder struct {
+	items []dlnaObject
+	paths []string
+}
+
+func (o episodeOrder) Len() int           { return len(o.items) }
+func (o episodeOrder) Less(i, j int) bool { return episodeLess(o.paths[i], o.paths[j]) }
+func (o episodeOrder) Swap(i, j int) {
+	o.items[i], o.items[j] = o.items[j], o.items[i]
+	o.paths[i], o.paths[j] = o.paths[j], o.paths[i]
+}
+
+// Render objects as a DIDL-Lite document
+func didlLite(objects []dlnaObject) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, object := range objects {
+		if object.URL == "" {
+			fmt.Fprintf(&b, `<container id="%s" parentID="%s" restricted="1" childCount="%d"><dc:title>%s</dc:title><upnp:class>object.container.storageFolder</upnp:class></container>`,
+				xmlEscape(object.ID), xmlEscape(object.ParentID), object.ChildCount, xmlEscape(object.Title))
+			continue
+		}
+		fmt.Fprintf(&b, `<item id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>object.item.videoItem</upnp:class><res protocolInfo="http-get:*:%s:DLNA.ORG_OP=01;DLNA.ORG_CI=0" size="%d">%s</res></item>`,
+			xmlEscape(object.ID), xmlEscape(object.ParentID), xmlEscape(object.Title),
+			object.ContentType, object.Size, xmlEscape(object.URL))
+	}
+	b.WriteString(`</DIDL-Lite>`)
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeXML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(xml.Header + body))
+}
+
+func writeSOAPResponse(w http.ResponseWriter, serviceType, action string, args [][2]string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%sResponse xmlns:u="%s">`, action, serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>%s</%s>", arg[0], xmlEscape(arg[1]), arg[0])
+	}
+	fmt.Fprintf(&b, "</u:%sResponse></s:Body></s:Envelope>", action)
+	writeXML(w, b.String())
+}
+
+func writeSOAPFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `%s<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		xml.Header, code, xmlEscape(description))
+}
+
+// Tell DLNA renderers the stream supports byte seeking
+func setDLNAHeaders(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("getcontentFeatures.dlna.org") != "" {
+		w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=0")
+	}
+	if r.Header.Get("transferMode.dlna.org") != "" {
+		w.Header().Set("transferMode.dlna.org", "Streaming")
+	}
+}
+
+// DLNA Settings Save Handler
+func saveDLNASettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings DLNASettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.EnableDLNA = newSettings.EnableDLNA
+	currentSettings.DLNAName = newSettings.DLNAName
+	err := saveSettingsToFile()
+	settingsMutex.Unlock()
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	restartDLNA()
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "DLNA settings saved successfully"})
+}
+
+const deviceDescription = `<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>` + mediaServerType + `</deviceType>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>BitPlay</manufacturer>
+    <modelName>BitPlay</modelName>
+    <UDN>uuid:%s</UDN>
+    <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+    <serviceList>
+      <service>
+        <serviceType>` + contentDirectoryType + `</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+        <SCPDURL>/dlna/ContentDirectory.xml</SCPDURL>
+        <controlURL>/dlna/control/ContentDirectory</controlURL>
+        <eventSubURL>/dlna/event/ContentDirectory</eventSubURL>
+      </service>
+      <service>
+        <serviceType>` + connectionManagerType + `</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+        <SCPDURL>/dlna/ConnectionManager.xml</SCPDURL>
+        <controlURL>/dlna/control/ConnectionManager</controlURL>
+        <eventSubURL>/dlna/event/ConnectionManager</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+const contentDirectorySCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>Browse</name>
+      <argumentList>
+        <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+        <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+        <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+        <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+        <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+        <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+        <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSearchCapabilities</name>
+      <argumentList>
+        <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSortCapabilities</name>
+      <argumentList>
+        <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSystemUpdateID</name>
+      <argumentList>
+        <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
+
+const connectionManagerSCPD = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>GetProtocolInfo</name>
+      <argumentList>
+        <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+        <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionIDs</name>
+      <argumentList>
+        <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionInfo</name>
+      <argumentList>
+        <argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+        <argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+        <argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+        <argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+        <argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+        <argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
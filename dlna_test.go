@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Add a torrent with the given files to an offline client and store it as
+// a session, removed again when the test ends
+func newTestSession(t *testing.T, paths ...string) (string, *TorrentSession) {
+	t.Helper()
+
+	config := torrent.NewDefaultClientConfig()
+	config.DefaultStorage = storage.NewFile(t.TempDir())
+	config.ListenPort = 0
+	config.NoDHT = true
+	config.DisableTrackers = true
+	config.NoDefaultPortForwarding = true
+	client, err := torrent.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	info := metainfo.Info{Name: "Show", PieceLength: 1 << 14}
+	for i, p := range paths {
+		info.Files = append(info.Files, metainfo.FileInfo{Path: strings.Split(p, "/"), Length: int64(1000 + i)})
+	}
+	pieces := (info.TotalLength() + info.PieceLength - 1) / info.PieceLength
+	info.Pieces = make([]byte, 20*pieces)
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tor, err := client.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID := tor.InfoHash().HexString()
+	session := &TorrentSession{Client: client, Torrent: tor, LastUsed: time.Now()}
+	sessions.Store(sessionID, session)
+	t.Cleanup(func() { sessions.Delete(sessionID) })
+	return sessionID, session
+}
+
+// Turn DLNA on for the length of a test
+func enableTestDLNA(t *testing.T) {
+	t.Helper()
+	settingsMutex.Lock()
+	saved := currentSettings
+	currentSettings.EnableDLNA = true
+	currentSettings.DLNAName = "Test Server"
+	currentSettings.PublicBaseURL = ""
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		currentSettings = saved
+		settingsMutex.Unlock()
+	})
+}
+
+func TestSSDPSearch(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertiser := &ssdpAdvertiser{uuid: "test-uuid", conn: conn, stop: make(chan struct{})}
+	advertiser.done.Add(1)
+	go advertiser.serve()
+	defer advertiser.close()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	search := func(target string) []*http.Response {
+		request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: %s\r\n\r\n", ssdpAddress, target)
+		if _, err := client.WriteToUDP([]byte(request), conn.LocalAddr().(*net.UDPAddr)); err != nil {
+			t.Fatal(err)
+		}
+
+		var responses []*http.Response
+		buf := make([]byte, 2048)
+		for {
+			client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+			n, _, err := client.ReadFromUDP(buf)
+			if err != nil {
+				return responses
+			}
+			response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+			if err != nil {
+				t.Fatalf("invalid SSDP response %q: %v", buf[:n], err)
+			}
+			responses = append(responses, response)
+		}
+	}
+
+	responses := search(mediaServerType)
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses to a MediaServer search, want 1", len(responses))
+	}
+	headers := responses[0].Header
+	wantHeaders := map[string]string{
+		"ST":            mediaServerType,
+		"USN":           "uuid:test-uuid::" + mediaServerType,
+		"LOCATION":      fmt.Sprintf("http://127.0.0.1:%d/dlna/device.xml", serverPort),
+		"CACHE-CONTROL": fmt.Sprintf("max-age=%d", ssdpMaxAge),
+		"SERVER":        ssdpServerHeader,
+	}
+	for name, want := range wantHeaders {
+		if got := headers.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, ok := headers["Ext"]; !ok {
+		t.Errorf("EXT header missing")
+	}
+
+	if responses := search("ssdp:all"); len(responses) != len(advertiser.targets()) {
+		t.Errorf("got %d responses to ssdp:all, want %d", len(responses), len(advertiser.targets()))
+	}
+	if responses := search("urn:schemas-upnp-org:device:MediaRenderer:1"); len(responses) != 0 {
+		t.Errorf("got %d responses to a MediaRenderer search, want none", len(responses))
+	}
+}
+
+type didlDocument struct {
+	Containers []struct {
+		ID         string `xml:"id,attr"`
+		ParentID   string `xml:"parentID,attr"`
+		ChildCount int    `xml:"childCount,attr"`
+		Title      string `xml:"title"`
+	} `xml:"container"`
+	Items []struct {
+		ID       string `xml:"id,attr"`
+		ParentID string `xml:"parentID,attr"`
+		Title    string `xml:"title"`
+		Class    string `xml:"class"`
+		Res      struct {
+			ProtocolInfo string `xml:"protocolInfo,attr"`
+			Size         int64  `xml:"size,attr"`
+			URL          string `xml:",chardata"`
+		} `xml:"res"`
+	} `xml:"item"`
+}
+
+// Send a ContentDirectory Browse and decode the DIDL-Lite result
+func browseTestDLNA(t *testing.T, objectID, flag string) (didlDocument, int) {
+	t.Helper()
+	body := fmt.Sprintf(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:Browse xmlns:u="%s"><ObjectID>%s</ObjectID><BrowseFlag>%s</BrowseFlag><Filter>*</Filter><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount><SortCriteria></SortCriteria></u:Browse></s:Body></s:Envelope>`,
+		contentDirectoryType, objectID, flag)
+	r := httptest.NewRequest("POST", "http://tv.example:3347/dlna/control/ContentDirectory", strings.NewReader(body))
+	r.Header.Set("SOAPAction", `"`+contentDirectoryType+`#Browse"`)
+	w := httptest.NewRecorder()
+	dlnaHandler(w, r)
+
+	var document didlDocument
+	if w.Code != http.StatusOK {
+		return document, w.Code
+	}
+
+	var envelope struct {
+		Result         string `xml:"Body>BrowseResponse>Result"`
+		NumberReturned int    `xml:"Body>BrowseResponse>NumberReturned"`
+		TotalMatches   int    `xml:"Body>BrowseResponse>TotalMatches"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("invalid SOAP response: %v", err)
+	}
+	if err := xml.Unmarshal([]byte(envelope.Result), &document); err != nil {
+		t.Fatalf("invalid DIDL-Lite %q: %v", envelope.Result, err)
+	}
+	if returned := len(document.Containers) + len(document.Items); envelope.NumberReturned != returned || envelope.TotalMatches != returned {
+		t.Errorf("NumberReturned = %d, TotalMatches = %d; want %d", envelope.NumberReturned, envelope.TotalMatches, returned)
+	}
+	return document, w.Code
+}
+
+func TestContentDirectoryBrowse(t *testing.T) {
+	useTestStreamKey()
+	enableTestDLNA(t)
+	sessionID, _ := newTestSession(t,
+		"Show.S01E10.mkv",
+		"Show.S01E02.mp4",
+		"Show.S01E02.en.srt",
+		"Sample/readme.txt",
+		"Show.S01E01.mkv",
+	)
+
+	root, _ := browseTestDLNA(t, "0", "BrowseDirectChildren")
+	if len(root.Containers) != 1 || len(root.Items) != 0 {
+		t.Fatalf("root = %+v, want one container", root)
+	}
+	if container := root.Containers[0]; container.ID != sessionID || container.ParentID != "0" || container.Title != "Show" || container.ChildCount != 3 {
+		t.Errorf("container = %+v", container)
+	}
+
+	children, _ := browseTestDLNA(t, sessionID, "BrowseDirectChildren")
+	wantItems := []struct {
+		index       int
+		title       string
+		contentType string
+	}{
+		{4, "Show.S01E01.mkv", "video/x-matroska"},
+		{1, "Show.S01E02.mp4", "video/mp4"},
+		{0, "Show.S01E10.mkv", "video/x-matroska"},
+	}
+	if len(children.Items) != len(wantItems) {
+		t.Fatalf("got %d items, want %d: %+v", len(children.Items), len(wantItems), children.Items)
+	}
+	for i, want := range wantItems {
+		item := children.Items[i]
+		if item.ID != fmt.Sprintf("%s/%d", sessionID, want.index) || item.ParentID != sessionID || item.Title != want.title {
+			t.Errorf("item %d = %+v, want %s", i, item, want.title)
+		}
+		if item.Class != "object.item.videoItem" || !strings.HasPrefix(item.Res.ProtocolInfo, "http-get:*:"+want.contentType+":") {
+			t.Errorf("item %d class %q, protocolInfo %q", i, item.Class, item.Res.ProtocolInfo)
+		}
+		if item.Res.Size != int64(1000+want.index) {
+			t.Errorf("item %d size = %d, want %d", i, item.Res.Size, 1000+want.index)
+		}
+
+		// Renderers get absolute links that pass the signature check
+		link, err := url.Parse(item.Res.URL)
+		if err != nil || link.Host != "tv.example:3347" || link.Path != fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, want.index) {
+			t.Errorf("item %d URL = %q", i, item.Res.URL)
+			continue
+		}
+		if !checkStreamSignature(httptest.NewRecorder(), httptest.NewRequest("GET", item.Res.URL, nil)) {
+			t.Errorf("item %d URL %q is not signed", i, item.Res.URL)
+		}
+	}
+
+	metadata, _ := browseTestDLNA(t, sessionID+"/1", "BrowseMetadata")
+	if len(metadata.Items) != 1 || metadata.Items[0].Title != "Show.S01E02.mp4" {
+		t.Errorf("item metadata = %+v", metadata)
+	}
+
+	if _, status := browseTestDLNA(t, sessionID+"/2", "BrowseMetadata"); status != http.StatusInternalServerError {
+		t.Errorf("browsing a subtitle returned %d, want a SOAP fault", status)
+	}
+	if _, status := browseTestDLNA(t, "unknown", "BrowseDirectChildren"); status != http.StatusInternalServerError {
+		t.Errorf("browsing an unknown object returned %d, want a SOAP fault", status)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var errFFmpegNotFound = errors.New("ffmpeg is not installed or not in PATH")
+
+// Matches "Duration: 00:42:10.12" in ffmpeg's input summary
+var ffmpegDurationPattern = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// Look up the ffmpeg binary at runtime, so it stays an optional dependency
+func findFFmpeg() (string, error) {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", errFFmpegNotFound
+	}
+	return ffmpegPath, nil
+}
+
+// Stream URL ffmpeg can read a torrent file from. Going through our own HTTP
+// server lets ffmpeg seek with Range requests while pieces download on demand.
+// The link is signed like any other handed to an outside player.
+func localStreamURL(sessionID string, fileIndex int) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s", serverPort, signStreamLink(fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, fileIndex)))
+}
+
+// Run ffmpeg and return its stderr, which is where it reports errors and progress
+func runFFmpeg(ctx context.Context, args ...string) ([]byte, error) {
+	ffmpegPath, err := findFFmpeg()
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, append([]string{"-hide_banner", "-nostdin"}, args...)...)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	return stderr.Bytes(), err
+}
+
+// Run ffmpeg with its output streamed to w. When ctx is done, e.g. because the
+// HTTP client disconnected, ffmpeg is interrupted so it can exit cleanly, and
+// killed if it hasn't exited a few seconds later.
+func streamFFmpeg(ctx context.Context, w io.Writer, args ...string) error {
+	ffmpegPath, err := findFFmpeg()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, append([]string{"-hide_banner", "-nostdin", "-loglevel", "error"}, args...)...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, stderr.Bytes())
+	}
+	return nil
+}
+
+// Read a media file's duration from ffmpeg's input summary
+func probeDuration(ctx context.Context, input string) (time.Duration, error) {
+	// Without an output ffmpeg exits with an error after printing the summary
+	output, err := runFFmpeg(ctx, "-i", input)
+	if errors.Is(err, errFFmpegNotFound) {
+		return 0, err
+	}
+
+	match := ffmpegDurationPattern.FindSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("could not determine duration of %s", input)
+	}
+
+	hours, _ := strconv.Atoi(string(match[1]))
+	minutes, _ := strconv.Atoi(string(match[2]))
+	seconds, _ := strconv.ParseFloat(string(match[3]), 64)
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)), nil
+}
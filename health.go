@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+const (
+	// How long a whole health check may take, and each tracker within it
+	healthCheckTimeout   = 10 * time.Second
+	trackerScrapeTimeout = 5 * time.Second
+	// Trackers scraped per torrent
+	maxScrapedTrackers = 8
+	// Search results checked at most per search
+	maxVerifyCount = 10
+)
+
+// Public trackers that know about most torrents, scraped in addition to
+// the ones in the magnet
+var fallbackScrapeTrackers = []string{
+	"udp://tracker.opentrackr.org:1337/announce",
+	"udp://open.stealth.si:80/announce",
+	"udp://tracker.torrent.eu.org:451/announce",
+}
+
+// Live swarm size of a torrent as reported by trackers and the DHT
+type TorrentHealth struct {
+	InfoHash string `json:"infoHash"`
+	// Best estimates across all sources
+	Seeders   int             `json:"seeders"`
+	Leechers  int             `json:"leechers"`
+	Trackers  []TrackerHealth `json:"trackers"`
+	DHT       *DHTHealth      `json:"dht,omitempty"`
+	Skipped   string          `json:"skipped,omitempty"`
+	CheckedAt time.Time       `json:"checkedAt"`
+}
+
+type TrackerHealth struct {
+	URL       string `json:"url"`
+	Seeders   int    `json:"seeders"`
+	Leechers  int    `json:"leechers"`
+	Completed int    `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Peers found with get_peers, and the BEP 33 swarm size estimates of the
+// nodes that support it
+type DHTHealth struct {
+	Peers    int    `json:"peers"`
+	Seeders  int    `json:"seeders"`
+	Leechers int    `json:"leechers"`
+	Nodes    uint32 `json:"nodes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DHT node kept for health checks, so its routing table warms up over time
+var (
+	healthDHT      *dht.Server
+	healthDHTMutex sync.Mutex
+)
+
+func healthCheckDHT() (*dht.Server, error) {
+	healthDHTMutex.Lock()
+	defer healthDHTMutex.Unlock()
+	if healthDHT == nil {
+		server, err := dht.NewServer(nil)
+		if err != nil {
+			return nil, err
+		}
+		healthDHT = server
+	}
+	return healthDHT, nil
+}
+
+// Stop the health check DHT node. It can't go through the proxy, so it must
+// not keep talking to other nodes while the proxy is enabled; the next check
+// without the proxy starts a new one.
+func closeHealthCheckDHT() {
+	healthDHTMutex.Lock()
+	defer healthDHTMutex.Unlock()
+	if healthDHT != nil {
+		healthDHT.Close()
+		healthDHT = nil
+	}
+}
+
+// Check how many peers a torrent has. UDP trackers and the DHT can't go
+// through the SOCKS5 proxy, so only HTTP trackers are asked while it's on.
+func checkTorrentHealth(ctx context.Context, magnet string) (*TorrentHealth, error) {
+	m, err := parseHealthCheckMagnet(magnet)
+	if err != nil {
+		return nil, err
+	}
+
+	settingsMutex.RLock()
+	enableProxy := currentSettings.EnableProxy
+	proxyURL := currentSettings.ProxyURL
+	settingsMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	health := &TorrentHealth{InfoHash: m.InfoHash.HexString()}
+	trackerURLs := scrapedTrackers(m.Trackers, enableProxy)
+	if enableProxy {
+		health.Skipped = "UDP trackers and the DHT are not checked while the proxy is enabled"
+		closeHealthCheckDHT()
+	}
+
+	var wg sync.WaitGroup
+	health.Trackers = make([]TrackerHealth, len(trackerURLs))
+	for i, trackerURL := range trackerURLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health.Trackers[i] = scrapeTracker(ctx, trackerURL, m.InfoHash, enableProxy, proxyURL)
+		}()
+	}
+	if !enableProxy {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health.DHT = dhtGetPeers(ctx, m.InfoHash)
+		}()
+	}
+	wg.Wait()
+
+	// Sources each see part of the swarm, so take the largest count
+	for _, trackerHealth := range health.Trackers {
+		health.Seeders = max(health.Seeders, trackerHealth.Seeders)
+		health.Leechers = max(health.Leechers, trackerHealth.Leechers)
+	}
+	if health.DHT != nil {
+		health.Seeders = max(health.Seeders, health.DHT.Seeders)
+		health.Leechers = max(health.Leechers, health.DHT.Leechers)
+	}
+	health.CheckedAt = time.Now()
+	return health, nil
+}
+
+// Parse a magnet link, or a bare info hash
+func parseHealthCheckMagnet(magnet string) (metainfo.Magnet, error) {
+	m, err := metainfo.ParseMagnetUri(magnet)
+	if err == nil {
+		return m, nil
+	}
+	var hash metainfo.Hash
+	if hash.FromHexString(magnet) != nil {
+		return metainfo.Magnet{}, errors.New("invalid magnet link or info hash")
+	}
+	return metainfo.Magnet{InfoHash: hash}, nil
+}
+
+// Trackers to scrape: the magnet's own and then the fallbacks, without
+// duplicates and at most maxScrapedTrackers. Only HTTP trackers go through
+// the proxy.
+func scrapedTrackers(magnetTrackers []string, enableProxy bool) []string {
+	var trackerURLs []string
+	seen := make(map[string]bool)
+	for _, trackerURL := range slices.Concat(magnetTrackers, fallbackScrapeTrackers) {
+		if seen[trackerURL] || len(trackerURLs) >= maxScrapedTrackers {
+			continue
+		}
+		seen[trackerURL] = true
+		if enableProxy && !strings.HasPrefix(trackerURL, "http") {
+			continue
+		}
+		trackerURLs = append(trackerURLs, trackerURL)
+	}
+	return trackerURLs
+}
+
+func scrapeTracker(ctx context.Context, trackerURL string, infoHash metainfo.Hash, enableProxy bool, proxyURL string) TrackerHealth {
+	result := TrackerHealth{URL: trackerURL}
+
+	var opts tracker.NewClientOpts
+	if enableProxy {
+		opts.Http.Proxy = func(*http.Request) (*url.URL, error) {
+			return url.Parse(proxyURL)
+		}
+	}
+	client, err := tracker.NewClient(trackerURL, opts)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, trackerScrapeTimeout)
+	defer cancel()
+	response, err := client.Scrape(ctx, []metainfo.Hash{infoHash})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if len(response) == 0 {
+		result.Error = "empty scrape response"
+		return result
+	}
+	result.Seeders = int(response[0].Seeders)
+	result.Leechers = int(response[0].Leechers)
+	result.Completed = int(response[0].Completed)
+	return result
+}
+
+// Look the torrent up in the DHT until the context ends
+func dhtGetPeers(ctx context.Context, infoHash metainfo.Hash) *DHTHealth {
+	result := &DHTHealth{}
+	server, err := healthCheckDHT()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	announce, err := server.AnnounceTraversal(infoHash, dht.Scrape())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer announce.Close()
+
+	// Bloom filters from different nodes combine into one for the swarm
+	var seeds, peers krpc.ScrapeBloomFilter
+	var haveScrape bool
+	addrs := make(map[string]bool)
+	merge := func(into *krpc.ScrapeBloomFilter, from *krpc.ScrapeBloomFilter) {
+		for i := range from {
+			into[i] |= from[i]
+		}
+	}
+traversal:
+	for {
+		select {
+		case <-ctx.Done():
+			break traversal
+		case values, ok := <-announce.Peers:
+			if !ok {
+				break traversal
+			}
+			for _, peer := range values.Peers {
+				addrs[peer.String()] = true
+			}
+			if values.BFsd != nil {
+				merge(&seeds, values.BFsd)
+				haveScrape = true
+			}
+			if values.BFpe != nil {
+				merge(&peers, values.BFpe)
+				haveScrape = true
+			}
+		}
+	}
+
+	result.Peers = len(addrs)
+	result.Nodes = announce.NumContacted()
+	if haveScrape {
+		result.Seeders = int(math.Round(seeds.EstimateCount()))
+		result.Leechers = int(math.Round(peers.EstimateCount()))
+	}
+	return result
+}
+
+// Check the swarms of the first count results, filling in their health
+func verifySearchResults(ctx context.Context, results []SearchResult, count int) {
+	var wg sync.WaitGroup
+	for i := range results[:min(count, len(results))] {
+		result := &results[i]
+		if result.MagnetURL == "" && result.InfoHash == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			magnet := result.MagnetURL
+			if magnet == "" {
+				magnet = result.InfoHash
+			}
+			health, err := checkTorrentHealth(ctx, magnet)
+			if err != nil {
+				log.Printf("Error checking health of %s: %v", result.Title, err)
+				return
+			}
+			result.Health = health
+		}()
+	}
+	wg.Wait()
+}
+
+// Check a torrent's live seeders and leechers:
+// /api/v1/search/verify?magnet=<magnet or info hash>
+// or ?downloadUrl=<indexer link>, which is resolved to a magnet first
+func verifyTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	magnet := r.URL.Query().Get("magnet")
+	if downloadURL := r.URL.Query().Get("downloadUrl"); magnet == "" && downloadURL != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), resolveTimeout)
+		defer cancel()
+
+		var err error
+		if magnet, err = resolveDownloadURL(ctx, downloadURL); err != nil {
+			respondWithJSON(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("Failed to resolve download link: %v", err)})
+			return
+		}
+	}
+	if magnet == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No magnet link provided"})
+		return
+	}
+
+	health, err := checkTorrentHealth(r.Context(), magnet)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, health)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHealthCheckMagnet(t *testing.T) {
+	tests := []struct {
+		magnet       string
+		wantTrackers []string
+		wantErr      bool
+	}{
+		{"magnet:?xt=urn:btih:" + testInfoHash, nil, false},
+		{"magnet:?xt=urn:btih:" + strings.ToUpper(testInfoHash) + "&tr=udp%3A%2F%2Ftracker.example%3A80", []string{"udp://tracker.example:80"}, false},
+		{testInfoHash, nil, false},
+		{strings.ToUpper(testInfoHash), nil, false},
+		{"", nil, true},
+		{testInfoHash[:39], nil, true},
+		{"magnet:?dn=no+hash", nil, true},
+		{"https://example.com/file.torrent", nil, true},
+	}
+	for _, tt := range tests {
+		m, err := parseHealthCheckMagnet(tt.magnet)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseHealthCheckMagnet(%q) succeeded, want an error", tt.magnet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHealthCheckMagnet(%q): %v", tt.magnet, err)
+			continue
+		}
+		if m.InfoHash.HexString() != testInfoHash || !reflect.DeepEqual(m.Trackers, tt.wantTrackers) {
+			t.Errorf("parseHealthCheckMagnet(%q) = %s, %q; want %s, %q",
+				tt.magnet, m.InfoHash.HexString(), m.Trackers, testInfoHash, tt.wantTrackers)
+		}
+	}
+
+	if _, err := checkTorrentHealth(context.Background(), "not a magnet"); err == nil {
+		t.Errorf("checkTorrentHealth accepted an invalid magnet")
+	}
+}
+
+func TestScrapedTrackers(t *testing.T) {
+	fallback := fallbackScrapeTrackers
+	many := make([]string, maxScrapedTrackers+2)
+	for i := range many {
+		many[i] = fmt.Sprintf("http://tracker%d.example/announce", i)
+	}
+
+	tests := []struct {
+		name        string
+		trackers    []string
+		enableProxy bool
+		want        []string
+	}{
+		{"fallbacks only", nil, false, fallback},
+		{"magnet trackers first", []string{"http://a.example/announce"}, false,
+			append([]string{"http://a.example/announce"}, fallback...)},
+		{"duplicates", []string{"http://a.example/announce", "http://a.example/announce", fallback[1]}, false,
+			[]string{"http://a.example/announce", fallback[1], fallback[0], fallback[2]}},
+		{"limit", many, false, many[:maxScrapedTrackers]},
+		{"proxy skips UDP", []string{"udp://a.example:80", "https://b.example/announce"}, true,
+			[]string{"https://b.example/announce"}},
+	}
+	for _, tt := range tests {
+		got := scrapedTrackers(tt.trackers, tt.enableProxy)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: scrapedTrackers = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(got) > maxScrapedTrackers {
+			t.Errorf("%s: %d trackers, want at most %d", tt.name, len(got), maxScrapedTrackers)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	historyFile = "config/history.json"
+	// Metainfo of history entries, so re-adding doesn't wait on peers for it
+	historyMetainfoDir = "config/history"
+)
+
+// A torrent that was added at some point, kept after its session is cleaned up
+type HistoryEntry struct {
+	InfoHash  string    `json:"infoHash"`
+	Name      string    `json:"name"`
+	Magnet    string    `json:"magnet"`
+	Size      int64     `json:"size"`
+	FileCount int       `json:"fileCount"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+var (
+	history      = make(map[string]HistoryEntry)
+	historyMutex sync.RWMutex
+)
+
+// Load the watch history from disk
+func loadHistory() {
+	var saved []HistoryEntry
+	if err := loadJSONFile(historyFile, &saved); err != nil {
+		log.Printf("Warning: Could not load watch history: %v", err)
+		return
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for _, entry := range saved {
+		history[entry.InfoHash] = entry
+	}
+	log.Printf("Loaded %d watch history entries", len(saved))
+}
+
+// Save the watch history to disk (assumes mutex is already locked)
+func saveHistory() error {
+	list := make([]HistoryEntry, 0, len(history))
+	for _, entry := range history {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AddedAt.After(list[j].AddedAt)
+	})
+	return saveJSONFile(historyFile, list)
+}
+
+func historyMetainfoPath(infoHash string) string {
+	return filepath.Join(historyMetainfoDir, infoHash+".torrent")
+}
+
+// Remember a torrent that was just added, along with its metainfo
+func recordHistory(t *torrent.Torrent, magnet string) {
+	infoHash := t.InfoHash().HexString()
+
+	if err := os.MkdirAll(historyMetainfoDir, 0755); err == nil {
+		if file, err := os.Create(historyMetainfoPath(infoHash)); err == nil {
+			mi := t.Metainfo()
+			if err := mi.Write(file); err != nil {
+				log.Printf("Warning: Could not save metainfo for %s: %v", infoHash, err)
+			}
+			file.Close()
+		}
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	history[infoHash] = HistoryEntry{
+		InfoHash:  infoHash,
+		Name:      t.Name(),
+		Magnet:    magnet,
+		Size:      t.Length(),
+		FileCount: len(t.Files()),
+		AddedAt:   time.Now(),
+	}
+	if err := saveHistory(); err != nil {
+		log.Printf("Warning: Could not save watch history: %v", err)
+	}
+}
+
+// Watch history:
+//
+//	GET    /api/v1/history?q=<search>   list entries, most recently watched first
+//	DELETE /api/v1/history/[infoHash]   forget an entry and its playback positions
+//	POST   /api/v1/history/[infoHash]/add   start a new session for an entry
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/history"), "/"), "/")
+	infoHash := strings.ToLower(parts[0])
+
+	switch {
+	case infoHash == "" && r.Method == http.MethodGet:
+		listHistory(w, r)
+	case infoHash != "" && len(parts) == 1 && r.Method == http.MethodDelete:
+		deleteHistory(w, infoHash)
+	case infoHash != "" && len(parts) == 2 && parts[1] == "add" && r.Method == http.MethodPost:
+		readdHistory(w, infoHash)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listHistory(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+
+	// Group saved positions by torrent
+	watched := make(map[string][]WatchProgress)
+	watchProgressMutex.RLock()
+	for _, progress := range sortedWatchProgress() {
+		watched[progress.InfoHash] = append(watched[progress.InfoHash], progress)
+	}
+	watchProgressMutex.RUnlock()
+
+	historyMutex.RLock()
+	var results []map[string]interface{}
+	for _, entry := range history {
+		if query != "" && !strings.Contains(strings.ToLower(entry.Name), query) {
+			continue
+		}
+
+		// Files are listed most recently watched first, so the first one is the last position
+		files := watched[entry.InfoHash]
+		lastWatched := entry.AddedAt
+		if len(files) > 0 && files[0].UpdatedAt.After(lastWatched) {
+			lastWatched = files[0].UpdatedAt
+		}
+		if files == nil {
+			files = []WatchProgress{}
+		}
+
+		_, active := sessions.Load(entry.InfoHash)
+		results = append(results, map[string]interface{}{
+			"infoHash":      entry.InfoHash,
+			"name":          entry.Name,
+			"magnet":        entry.Magnet,
+			"size":          formatSize(float64(entry.Size)),
+			"sizeBytes":     entry.Size,
+			"fileCount":     entry.FileCount,
+			"addedAt":       entry.AddedAt,
+			"lastWatchedAt": lastWatched,
+			"files":         files,
+			"active":        active,
+		})
+	}
+	historyMutex.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i]["lastWatchedAt"].(time.Time).After(results[j]["lastWatchedAt"].(time.Time))
+	})
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+func deleteHistory(w http.ResponseWriter, infoHash string) {
+	historyMutex.Lock()
+	if _, ok := history[infoHash]; !ok {
+		historyMutex.Unlock()
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "History entry not found"})
+		return
+	}
+	delete(history, infoHash)
+	err := saveHistory()
+	historyMutex.Unlock()
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save history: " + err.Error()})
+		return
+	}
+
+	os.Remove(historyMetainfoPath(infoHash))
+	removePreviews(infoHash)
+	if err := deleteWatchProgress(infoHash); err != nil {
+		log.Printf("Warning: Could not save watch progress: %v", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "History entry removed"})
+}
+
+// Start watching a history entry again, reusing its session if it's still around
+func readdHistory(w http.ResponseWriter, infoHash string) {
+	historyMutex.RLock()
+	entry, ok := history[infoHash]
+	historyMutex.RUnlock()
+	if !ok {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "History entry not found"})
+		return
+	}
+
+	if value, ok := sessions.Load(infoHash); ok {
+		value.(*TorrentSession).LastUsed = time.Now()
+		respondWithJSON(w, http.StatusOK, map[string]string{"sessionId": infoHash})
+		return
+	}
+
+	t, status, err := startTorrentSession(func(client *torrent.Client) (*torrent.Torrent, error) {
+		// The saved metainfo skips fetching it from peers, which is the slow part
+		if mi, err := metainfo.LoadFromFile(historyMetainfoPath(infoHash)); err == nil {
+			t, err := client.AddTorrent(mi)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid saved torrent: %v", err)
+			}
+			return t, nil
+		}
+		t, err := client.AddMagnet(entry.Magnet)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid magnet url in history: %v", err)
+		}
+		return t, nil
+	})
+	if err != nil {
+		respondWithJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	recordHistory(t, entry.Magnet)
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"sessionId": t.InfoHash().HexString()})
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A torrent search source such as Prowlarr or Jackett. New sources only
+// need to implement this to show up in search.
+type Indexer interface {
+	Name() string
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+	// Check that the indexer is reachable and the credentials work,
+	// returning the indexer's answer
+	Test(ctx context.Context) ([]byte, error)
+}
+
+// A single release returned by an indexer
+type SearchResult struct {
+	Title        string `json:"title"`
+	MagnetURL    string `json:"magnetUrl,omitempty"`
+	DownloadURL  string `json:"downloadUrl,omitempty"`
+	DirectMagnet bool   `json:"directMagnet"`
+	InfoHash     string `json:"infoHash,omitempty"`
+	Size         string `json:"size,omitempty"`
+	SizeBytes    int64  `json:"sizeBytes,omitempty"`
+	Seeders      int    `json:"seeders"`
+	Leechers     int    `json:"leechers"`
+	Indexer      string `json:"indexer,omitempty"`
+	PublishDate  string `json:"publishDate,omitempty"`
+	Category     string `json:"category,omitempty"`
+	// The Indexer implementation the result came from
+	Backend string `json:"backend,omitempty"`
+	// Quality, season and episode parsed from the title
+	Release ReleaseInfo `json:"release"`
+	// Set when the release is playing in a session or was watched before
+	Active    bool `json:"active,omitempty"`
+	InHistory bool `json:"inHistory,omitempty"`
+	// Live swarm size, when the result was verified
+	Health *TorrentHealth `json:"health,omitempty"`
+}
+
+// Build a result from an indexer's fields. Results without a title or any
+// link are dropped; a magnet link is preferred over a download link.
+//
+// Prowlarr often gives an http(s) link that redirects to the magnet in place
+// of the magnet itself. That is kept as a download link, which adding the
+// torrent or resolving results follows.
+func newSearchResult(backend, title, magnetURL, downloadURL string, size int64) (SearchResult, bool) {
+	if title == "" {
+		return SearchResult{}, false
+	}
+
+	result := SearchResult{Title: title, Backend: backend, SizeBytes: size, Release: parseReleaseName(title)}
+	switch {
+	case strings.HasPrefix(magnetURL, "magnet:"):
+		result.MagnetURL = magnetURL
+		result.DirectMagnet = true
+		result.InfoHash = magnetInfoHash(magnetURL)
+	case isHTTPLink(downloadURL):
+		result.DownloadURL = downloadURL
+	case isHTTPLink(magnetURL):
+		result.DownloadURL = magnetURL
+	default:
+		return SearchResult{}, false
+	}
+	if size > 0 {
+		result.Size = formatSize(float64(size))
+	}
+	return result, true
+}
+
+func isHTTPLink(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+// An indexer answered with a non-200 status
+type indexerStatusError struct {
+	Backend    string
+	StatusCode int
+	Body       string
+}
+
+func (e *indexerStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.Backend, e.StatusCode, e.Body)
+}
+
+// A search the backend can't run, e.g. a TMDb ID search on Jackett
+type unsupportedSearchError struct {
+	Backend string
+	Reason  string
+}
+
+func (e *unsupportedSearchError) Error() string {
+	return fmt.Sprintf("%s %s", e.Backend, e.Reason)
+}
+
+// Report a failed indexer request, passing its status through when it answered
+func respondWithIndexerError(w http.ResponseWriter, err error) {
+	log.Printf("Error querying indexer: %v", err)
+	var statusErr *indexerStatusError
+	if errors.As(err, &statusErr) {
+		respondWithJSON(w, statusErr.StatusCode, map[string]string{"error": statusErr.Error()})
+		return
+	}
+	var unsupportedErr *unsupportedSearchError
+	if errors.As(err, &unsupportedErr) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": unsupportedErr.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// GET an indexer URL and return the body, treating non-200 answers as errors
+func fetchIndexerBody(ctx context.Context, client *http.Client, backend, requestURL string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// Use the client that bypasses proxy for indexers unless one is given
+	if client == nil {
+		client = createSelectiveProxyClient()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", backend, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response", backend)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &indexerStatusError{Backend: backend, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
+
+// Releases to ask Prowlarr for; paging happens on our side after merging
+const prowlarrSearchLimit = 100
+
+// Indexers that aggregate several trackers and can search each of them on
+// its own, so results can be shown as each tracker answers
+type trackerIndexer interface {
+	Indexer
+	Trackers(ctx context.Context) ([]Indexer, error)
+}
+
+// Prowlarr's search API
+type ProwlarrIndexer struct {
+	Host   string
+	APIKey string
+	// Optional, defaults to the proxy-aware client
+	Client *http.Client
+	// Search only this Prowlarr indexer instead of all of them
+	IndexerID   int
+	IndexerName string
+}
+
+func (p *ProwlarrIndexer) Name() string {
+	if p.IndexerName != "" {
+		return "Prowlarr: " + p.IndexerName
+	}
+	return "Prowlarr"
+}
+
+func (p *ProwlarrIndexer) get(ctx context.Context, apiPath string) ([]byte, error) {
+	return fetchIndexerBody(ctx, p.Client, p.Name(), strings.TrimRight(p.Host, "/")+apiPath,
+		map[string]string{"X-Api-Key": p.APIKey})
+}
+
+// Prowlarr's enabled torrent indexers
+func (p *ProwlarrIndexer) Trackers(ctx context.Context) ([]Indexer, error) {
+	body, err := p.get(ctx, "/api/v1/indexer")
+	if err != nil {
+		return nil, err
+	}
+
+	var indexers []struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Enable   bool   `json:"enable"`
+		Protocol string `json:"protocol"`
+	}
+	if err := json.Unmarshal(body, &indexers); err != nil {
+		return nil, errors.New("failed to parse Prowlarr indexers")
+	}
+
+	var trackers []Indexer
+	for _, indexer := range indexers {
+		if indexer.Enable && indexer.Protocol == "torrent" {
+			trackers = append(trackers, &ProwlarrIndexer{Host: p.Host, APIKey: p.APIKey, Client: p.Client, IndexerID: indexer.ID, IndexerName: indexer.Name})
+		}
+	}
+	return trackers, nil
+}
+
+// Prowlarr takes IDs, seasons and episodes as tokens in the query text,
+// e.g. "Show {Season:01}{Episode:02}"
+func prowlarrQueryText(query SearchQuery) string {
+	text := query.Query + " "
+	if query.IMDbID != "" {
+		text += "{ImdbId:" + query.IMDbID + "}"
+	}
+	if query.TMDbID > 0 {
+		text += fmt.Sprintf("{TmdbId:%d}", query.TMDbID)
+	}
+	if query.Year > 0 {
+		text += fmt.Sprintf("{Year:%d}", query.Year)
+	}
+	if query.Season > 0 {
+		text += fmt.Sprintf("{Season:%02d}", query.Season)
+	}
+	if query.Episode > 0 {
+		text += fmt.Sprintf("{Episode:%02d}", query.Episode)
+	}
+	return strings.TrimSpace(text)
+}
+
+func (p *ProwlarrIndexer) Test(ctx context.Context) ([]byte, error) {
+	return p.get(ctx, "/api/v1/system/status")
+}
+
+func (p *ProwlarrIndexer) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	params := url.Values{
+		"query": {prowlarrQueryText(query)},
+		"type":  {query.Type},
+		"limit": {strconv.Itoa(prowlarrSearchLimit)},
+	}
+	for _, category := range query.Categories {
+		params.Add("categories", strconv.Itoa(category))
+	}
+	if p.IndexerID > 0 {
+		params.Set("indexerIds", strconv.Itoa(p.IndexerID))
+	}
+
+	body, err := p.get(ctx, "/api/v1/search?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []struct {
+		Title       string `json:"title"`
+		DownloadURL string `json:"downloadUrl"`
+		MagnetURL   string `json:"magnetUrl"`
+		InfoHash    string `json:"infoHash"`
+		Size        int64  `json:"size"`
+		Seeders     int    `json:"seeders"`
+		Leechers    int    `json:"leechers"`
+		Indexer     string `json:"indexer"`
+		PublishDate string `json:"publishDate"`
+		Categories  []struct {
+			Name string `json:"name"`
+		} `json:"categories"`
+	}
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, errors.New("failed to parse Prowlarr response")
+	}
+
+	var results []SearchResult
+	for _, release := range releases {
+		result, ok := newSearchResult(p.Name(), release.Title, release.MagnetURL, release.DownloadURL, release.Size)
+		if !ok {
+			continue
+		}
+		if release.InfoHash != "" {
+			result.InfoHash = strings.ToLower(release.InfoHash)
+		}
+		result.Seeders = release.Seeders
+		result.Leechers = release.Leechers
+		result.Indexer = release.Indexer
+		result.PublishDate = release.PublishDate
+		if len(release.Categories) > 0 {
+			result.Category = release.Categories[0].Name
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Jackett's aggregate search API
+type JackettIndexer struct {
+	Host   string
+	APIKey string
+	// Optional, defaults to the proxy-aware client
+	Client *http.Client
+	// Search only this Jackett indexer instead of all configured ones
+	TrackerID   string
+	TrackerName string
+}
+
+func (j *JackettIndexer) Name() string {
+	if j.TrackerName != "" {
+		return "Jackett: " + j.TrackerName
+	}
+	return "Jackett"
+}
+
+func (j *JackettIndexer) get(ctx context.Context, apiPath string, params url.Values) ([]byte, error) {
+	params.Set("apikey", j.APIKey)
+	return fetchIndexerBody(ctx, j.Client, j.Name(),
+		strings.TrimRight(j.Host, "/")+apiPath+"?"+params.Encode(), nil)
+}
+
+func (j *JackettIndexer) resultsPath() string {
+	trackerID := "all"
+	if j.TrackerID != "" {
+		trackerID = url.PathEscape(j.TrackerID)
+	}
+	return "/api/v2.0/indexers/" + trackerID + "/results"
+}
+
+// Jackett's configured indexers
+func (j *JackettIndexer) Trackers(ctx context.Context) ([]Indexer, error) {
+	body, err := j.get(ctx, "/api/v2.0/indexers", url.Values{"configured": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var indexers []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Configured bool   `json:"configured"`
+	}
+	if err := json.Unmarshal(body, &indexers); err != nil {
+		return nil, errors.New("failed to parse Jackett indexers")
+	}
+
+	var trackers []Indexer
+	for _, indexer := range indexers {
+		if indexer.Configured {
+			trackers = append(trackers, &JackettIndexer{Host: j.Host, APIKey: j.APIKey, Client: j.Client, TrackerID: indexer.ID, TrackerName: indexer.Name})
+		}
+	}
+	return trackers, nil
+}
+
+func (j *JackettIndexer) Test(ctx context.Context) ([]byte, error) {
+	return j.get(ctx, j.resultsPath(), url.Values{})
+}
+
+// Jackett's results API has no structured search, so TV and movie searches
+// are sent as text within their categories. A TMDb ID has no text form, so
+// a search by it alone is refused rather than sent as an empty query, which
+// Jackett answers with its latest releases.
+func (j *JackettIndexer) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	if query.Query == "" && query.IMDbID == "" {
+		return nil, &unsupportedSearchError{Backend: j.Name(), Reason: "can't search by tmdbId alone, add q or imdbId"}
+	}
+
+	params := url.Values{"Query": {query.Text()}}
+	for _, category := range query.Categories {
+		params.Add("Category[]", strconv.Itoa(category))
+	}
+
+	body, err := j.get(ctx, j.resultsPath(), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Results []struct {
+			Title        string `json:"Title"`
+			Link         string `json:"Link"`
+			MagnetURI    string `json:"MagnetUri"`
+			InfoHash     string `json:"InfoHash"`
+			Size         int64  `json:"Size"`
+			Seeders      int    `json:"Seeders"`
+			Peers        int    `json:"Peers"`
+			Tracker      string `json:"Tracker"`
+			PublishDate  string `json:"PublishDate"`
+			CategoryDesc string `json:"CategoryDesc"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, errors.New("failed to parse Jackett response")
+	}
+
+	var results []SearchResult
+	for _, release := range response.Results {
+		result, ok := newSearchResult(j.Name(), release.Title, release.MagnetURI, release.Link, release.Size)
+		if !ok {
+			continue
+		}
+		if release.InfoHash != "" {
+			result.InfoHash = strings.ToLower(release.InfoHash)
+		}
+		result.Seeders = release.Seeders
+		result.Leechers = release.Peers
+		result.Indexer = release.Tracker
+		result.PublishDate = release.PublishDate
+		result.Category = release.CategoryDesc
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Indexers configured in settings, whether or not they are enabled
+func prowlarrFromSettings() *ProwlarrIndexer {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return &ProwlarrIndexer{Host: currentSettings.ProwlarrHost, APIKey: currentSettings.ProwlarrApiKey}
+}
+
+func jackettFromSettings() *JackettIndexer {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return &JackettIndexer{Host: currentSettings.JackettHost, APIKey: currentSettings.JackettApiKey}
+}
+
+// Indexers that are enabled and have a host and API key
+func enabledIndexers() []Indexer {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+
+	var indexers []Indexer
+	if currentSettings.EnableProwlarr && currentSettings.ProwlarrHost != "" && currentSettings.ProwlarrApiKey != "" {
+		indexers = append(indexers, &ProwlarrIndexer{Host: currentSettings.ProwlarrHost, APIKey: currentSettings.ProwlarrApiKey})
+	}
+	if currentSettings.EnableJackett && currentSettings.JackettHost != "" && currentSettings.JackettApiKey != "" {
+		indexers = append(indexers, &JackettIndexer{Host: currentSettings.JackettHost, APIKey: currentSettings.JackettApiKey})
+	}
+	for _, torznab := range currentSettings.TorznabIndexers {
+		if torznab.Enabled && torznab.URL != "" {
+			indexers = append(indexers, newTorznabIndexer(torznab))
+		}
+	}
+	return indexers
+}
+
+// Search a single indexer: POST /api/v1/{prowlarr,jackett}/search?q=<query>
+//
+// Takes the same search, paging, sorting, filtering and refresh parameters
+// as the unified search, but returns all results unless a limit is given.
+// The number of matches before paging is sent in X-Total-Count, and whether
+// the results were cached in X-Cache and Age.
+func serveIndexerSearch(w http.ResponseWriter, r *http.Request, indexer Indexer, configured bool) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Prowlarr-Host, X-Api-Key")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Cache, Age")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	options, err := parseSearchOptions(r.URL.Query(), 0)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if !configured {
+		http.Error(w, indexer.Name()+" host or API key not set", http.StatusBadRequest)
+		return
+	}
+
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	results, cacheStatus, err := cachedSearch(r.Context(), indexer, query, refresh)
+	if err != nil {
+		respondWithIndexerError(w, err)
+		return
+	}
+	if cacheStatus.Hit {
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("Age", strconv.Itoa(cacheStatus.Age))
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	results, total := options.apply(results)
+	if options.Resolve > 0 {
+		results, _ = resolveSearchResults(r.Context(), results, options.Resolve)
+	}
+	if options.Verify > 0 {
+		verifySearchResults(r.Context(), results, options.Verify)
+	}
+	flagKnownResults(results)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// Check an indexer's connection with settings posted from the settings page
+func serveIndexerTest(w http.ResponseWriter, r *http.Request, indexer Indexer, configured bool) {
+	if !configured {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": indexer.Name() + " host or API key not set"})
+		return
+	}
+
+	body, err := indexer.Test(r.Context())
+	if err != nil {
+		respondWithIndexerError(w, err)
+		return
+	}
+
+	// Pass Prowlarr's and Jackett's JSON answers through unchanged, as these
+	// endpoints always have. Torznab answers in XML, so it gets a message.
+	if !json.Valid(body) {
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": indexer.Name() + " connection successful"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testInfoHash = "0123456789abcdef0123456789abcdef01234567"
+
+// Serve a canned body for one path, checking the API key and recording the query
+func fakeIndexerServer(t *testing.T, path, contentType, body string, checkKey func(*http.Request) bool) (*httptest.Server, *url.Values) {
+	t.Helper()
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		if !checkKey(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &query
+}
+
+func TestNewSearchResult(t *testing.T) {
+	magnet := "magnet:?xt=urn:btih:" + testInfoHash
+	tests := []struct {
+		name         string
+		title        string
+		magnetURL    string
+		downloadURL  string
+		wantOK       bool
+		wantMagnet   string
+		wantDownload string
+	}{
+		{"magnet", "Show", magnet, "http://indexer/dl/1", true, magnet, ""},
+		{"download link", "Show", "", "http://indexer/dl/1", true, "", "http://indexer/dl/1"},
+		{"http magnet link", "Show", "http://prowlarr/api/v1/indexer/1/download?link=x", "", true, "", "http://prowlarr/api/v1/indexer/1/download?link=x"},
+		{"download link preferred", "Show", "https://indexer/magnet/1", "https://indexer/dl/1", true, "", "https://indexer/dl/1"},
+		{"no link", "Show", "", "", false, "", ""},
+		{"unknown scheme", "Show", "ftp://indexer/1", "", false, "", ""},
+		{"no title", "", magnet, "", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := newSearchResult("Test", tt.title, tt.magnetURL, tt.downloadURL, 0)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if result.MagnetURL != tt.wantMagnet || result.DownloadURL != tt.wantDownload {
+				t.Errorf("magnet %q, download %q; want %q, %q", result.MagnetURL, result.DownloadURL, tt.wantMagnet, tt.wantDownload)
+			}
+			if result.DirectMagnet != (tt.wantMagnet != "") {
+				t.Errorf("DirectMagnet = %v", result.DirectMagnet)
+			}
+		})
+	}
+}
+
+func TestProwlarrSearch(t *testing.T) {
+	const body = `[
+		{"title": "Show.S01E02.1080p.WEB.x264-GRP", "magnetUrl": "magnet:?xt=urn:btih:` + testInfoHash + `", "size": 1500000000, "seeders": 12, "leechers": 3, "indexer": "Tracker A", "publishDate": "2024-01-02T03:04:05Z", "categories": [{"name": "TV/HD"}]},
+		{"title": "Show.S01E02.720p.HDTV", "magnetUrl": "http://prowlarr/api/v1/indexer/2/download?link=abc", "infoHash": "ABCDEF0123456789ABCDEF0123456789ABCDEF01", "size": 700000000, "seeders": 4, "indexer": "Tracker B"},
+		{"title": "Show.S01E02.Usenet", "downloadUrl": "", "magnetUrl": ""}
+	]`
+	server, query := fakeIndexerServer(t, "/api/v1/search", "application/json", body, func(r *http.Request) bool {
+		return r.Header.Get("X-Api-Key") == "key"
+	})
+
+	prowlarr := &ProwlarrIndexer{Host: server.URL + "/", APIKey: "key", Client: server.Client(), IndexerID: 7, IndexerName: "Tracker A"}
+	results, err := prowlarr.Search(context.Background(), SearchQuery{Query: "Show", Type: "tvsearch", Categories: []int{5000}, Season: 1, Episode: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery := url.Values{
+		"query":      {"Show {Season:01}{Episode:02}"},
+		"type":       {"tvsearch"},
+		"limit":      {"100"},
+		"categories": {"5000"},
+		"indexerIds": {"7"},
+	}
+	if !reflect.DeepEqual(*query, wantQuery) {
+		t.Errorf("query = %v, want %v", *query, wantQuery)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	first := results[0]
+	if first.Backend != "Prowlarr: Tracker A" || !first.DirectMagnet || first.InfoHash != testInfoHash ||
+		first.SizeBytes != 1500000000 || first.Seeders != 12 || first.Leechers != 3 ||
+		first.Indexer != "Tracker A" || first.Category != "TV/HD" || first.Release.Resolution != "1080p" {
+		t.Errorf("first result = %+v", first)
+	}
+	second := results[1]
+	if second.DirectMagnet || second.DownloadURL != "http://prowlarr/api/v1/indexer/2/download?link=abc" ||
+		second.InfoHash != "abcdef0123456789abcdef0123456789abcdef01" {
+		t.Errorf("second result = %+v", second)
+	}
+
+	prowlarr.APIKey = "wrong"
+	if _, err := prowlarr.Search(context.Background(), SearchQuery{Query: "Show", Type: "search"}); err == nil {
+		t.Errorf("search with a wrong API key succeeded")
+	}
+}
+
+func TestJackettSearch(t *testing.T) {
+	const body = `{"Results": [
+		{"Title": "Movie.2020.2160p.BluRay.x265-GRP", "Link": "http://jackett/dl/1", "MagnetUri": "magnet:?xt=urn:btih:` + testInfoHash + `", "Size": 20000000000, "Seeders": 30, "Peers": 5, "Tracker": "Tracker C", "CategoryDesc": "Movies/UHD"},
+		{"Title": "Movie.2020.1080p.WEB", "Link": "http://jackett/dl/2", "InfoHash": "ABCDEF0123456789ABCDEF0123456789ABCDEF01", "Seeders": 8, "Tracker": "Tracker C"},
+		{"Title": "", "Link": "http://jackett/dl/3"}
+	]}`
+	server, query := fakeIndexerServer(t, "/api/v2.0/indexers/tracker-c/results", "application/json", body, func(r *http.Request) bool {
+		return r.URL.Query().Get("apikey") == "key"
+	})
+
+	jackett := &JackettIndexer{Host: server.URL, APIKey: "key", Client: server.Client(), TrackerID: "tracker-c", TrackerName: "Tracker C"}
+	results, err := jackett.Search(context.Background(), SearchQuery{Query: "Movie", Type: "movie", Categories: []int{2000}, Year: 2020})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery := url.Values{"Query": {"Movie 2020"}, "Category[]": {"2000"}, "apikey": {"key"}}
+	if !reflect.DeepEqual(*query, wantQuery) {
+		t.Errorf("query = %v, want %v", *query, wantQuery)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	first := results[0]
+	if first.Backend != "Jackett: Tracker C" || !first.DirectMagnet || first.DownloadURL != "" || first.InfoHash != testInfoHash ||
+		first.Seeders != 30 || first.Leechers != 5 || first.Category != "Movies/UHD" || first.Release.Resolution != "2160p" {
+		t.Errorf("first result = %+v", first)
+	}
+	second := results[1]
+	if second.DirectMagnet || second.DownloadURL != "http://jackett/dl/2" || second.InfoHash != "abcdef0123456789abcdef0123456789abcdef01" {
+		t.Errorf("second result = %+v", second)
+	}
+}
+
+func TestJackettSearchTMDbOnly(t *testing.T) {
+	server, query := fakeIndexerServer(t, "/api/v2.0/indexers/all/results", "application/json", `{"Results": []}`, func(r *http.Request) bool {
+		return true
+	})
+
+	jackett := &JackettIndexer{Host: server.URL, APIKey: "key", Client: server.Client()}
+	_, err := jackett.Search(context.Background(), SearchQuery{Type: "movie", Categories: []int{2000}, TMDbID: 603, Year: 1999})
+	var unsupportedErr *unsupportedSearchError
+	if !errors.As(err, &unsupportedErr) {
+		t.Errorf("err = %v, want an unsupported search", err)
+	}
+	if *query != nil {
+		t.Errorf("Jackett was asked %v", *query)
+	}
+
+	if _, err := jackett.Search(context.Background(), SearchQuery{Type: "movie", IMDbID: "tt0133093", TMDbID: 603}); err != nil {
+		t.Errorf("search with an IMDb ID failed: %v", err)
+	}
+	if got := (*query).Get("Query"); got != "tt0133093" {
+		t.Errorf("Query = %q, want the IMDb ID", got)
+	}
+}
+
+// Jackett also serves each tracker as a Torznab feed, which TorznabIndexer reads
+func TestTorznabSearch(t *testing.T) {
+	const body = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+	<item>
+		<title>Show.S02E03.1080p.WEB.h264-GRP</title>
+		<link>http://jackett/dl/1</link>
+		<pubDate>Tue, 02 Jan 2024 03:04:05 +0000</pubDate>
+		<size>1200000000</size>
+		<category>TV/HD</category>
+		<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:` + testInfoHash + `"/>
+		<torznab:attr name="seeders" value="20"/>
+		<torznab:attr name="peers" value="26"/>
+	</item>
+	<item>
+		<title>Show.S02E03.720p.HDTV</title>
+		<enclosure url="http://jackett/dl/2" length="600000000" type="application/x-bittorrent"/>
+		<torznab:attr name="infohash" value="ABCDEF0123456789ABCDEF0123456789ABCDEF01"/>
+		<torznab:attr name="seeders" value="2"/>
+	</item>
+</channel>
+</rss>`
+	server, query := fakeIndexerServer(t, "/api/v2.0/indexers/tracker-c/results/torznab/api", "application/rss+xml", body, func(r *http.Request) bool {
+		return r.URL.Query().Get("apikey") == "key"
+	})
+
+	torznab := &TorznabIndexer{Title: "Tracker C", URL: server.URL + "/api/v2.0/indexers/tracker-c/results/torznab/api", APIKey: "key", Client: server.Client()}
+	results, err := torznab.Search(context.Background(), SearchQuery{Query: "Show", Type: "tvsearch", Categories: []int{5000, 5040}, Season: 2, Episode: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery := url.Values{"t": {"tvsearch"}, "q": {"Show"}, "cat": {"5000,5040"}, "season": {"2"}, "ep": {"3"}, "apikey": {"key"}}
+	if !reflect.DeepEqual(*query, wantQuery) {
+		t.Errorf("query = %v, want %v", *query, wantQuery)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	first := results[0]
+	if first.Backend != "Tracker C" || !first.DirectMagnet || first.InfoHash != testInfoHash || first.SizeBytes != 1200000000 ||
+		first.Seeders != 20 || first.Leechers != 6 || first.Category != "TV/HD" || first.Release.Season != 2 {
+		t.Errorf("first result = %+v", first)
+	}
+	second := results[1]
+	if second.DirectMagnet || second.DownloadURL != "http://jackett/dl/2" || second.SizeBytes != 600000000 ||
+		second.InfoHash != "abcdef0123456789abcdef0123456789abcdef01" || second.Seeders != 2 {
+		t.Errorf("second result = %+v", second)
+	}
+}
+
+func TestTorznabError(t *testing.T) {
+	server, _ := fakeIndexerServer(t, "/api", "application/xml", `<?xml version="1.0"?><error code="100" description="Invalid API Key"/>`,
+		func(r *http.Request) bool { return true })
+
+	torznab := &TorznabIndexer{URL: server.URL + "/api", Client: server.Client()}
+	if _, err := torznab.Search(context.Background(), SearchQuery{Query: "Show", Type: "search"}); err == nil {
+		t.Errorf("an error feed was treated as results")
+	}
+}
+
+func TestSearchFromTorznab(t *testing.T) {
+	const body = `<?xml version="1.0"?><rss><channel><item><title>Show.S01E01</title><link>http://tracker/dl/1</link></item></channel></rss>`
+	server, _ := fakeIndexerServer(t, "/api", "application/rss+xml", body, func(r *http.Request) bool { return true })
+
+	settingsMutex.Lock()
+	saved := currentSettings
+	currentSettings.EnableProxy = false
+	currentSettings.TorznabIndexers = []TorznabSettings{
+		{Enabled: false, Name: "Off", URL: server.URL + "/api"},
+		{Enabled: true, Name: "On", URL: server.URL + "/api"},
+	}
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		currentSettings = saved
+		settingsMutex.Unlock()
+	})
+
+	tests := []struct {
+		method string
+		name   string
+		want   int
+	}{
+		{"OPTIONS", "Off", http.StatusOK},
+		{"POST", "On", http.StatusOK},
+		{"POST", "", http.StatusOK},
+		{"POST", "Off", http.StatusNotFound},
+		{"POST", "Missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		searchFromTorznab(w, httptest.NewRequest(tt.method, "/api/v1/torznab/search?refresh=true&q=Show&name="+tt.name, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %q: status = %d, want %d (%s)", tt.method, tt.name, w.Code, tt.want, w.Body.String())
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s %q: CORS header missing", tt.method, tt.name)
+		}
+	}
+}
+
+func TestServeIndexerTest(t *testing.T) {
+	const status = `{"appName": "Prowlarr", "version": "1.2.3"}`
+	prowlarrServer, _ := fakeIndexerServer(t, "/api/v1/system/status", "application/json", status, func(r *http.Request) bool {
+		return r.Header.Get("X-Api-Key") == "key"
+	})
+	torznabServer, _ := fakeIndexerServer(t, "/api", "application/xml", `<?xml version="1.0"?><caps><server title="Tracker"/></caps>`, func(r *http.Request) bool {
+		return true
+	})
+
+	tests := []struct {
+		name       string
+		indexer    Indexer
+		configured bool
+		wantStatus int
+		wantBody   string
+	}{
+		{"passes the answer through", &ProwlarrIndexer{Host: prowlarrServer.URL, APIKey: "key", Client: prowlarrServer.Client()}, true, http.StatusOK, status},
+		{"upstream status", &ProwlarrIndexer{Host: prowlarrServer.URL, APIKey: "wrong", Client: prowlarrServer.Client()}, true, http.StatusUnauthorized, ""},
+		{"not configured", &ProwlarrIndexer{}, false, http.StatusBadRequest, ""},
+		{"xml answer", &TorznabIndexer{Title: "Tracker", URL: torznabServer.URL + "/api", Client: torznabServer.Client()}, true, http.StatusOK, `{"message":"Tracker connection successful"}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		serveIndexerTest(w, httptest.NewRequest("POST", "/api/v1/prowlarr/test", nil), tt.indexer, tt.configured)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if body := strings.TrimSpace(w.Body.String()); tt.wantBody != "" && body != tt.wantBody {
+			t.Errorf("%s: body = %s, want %s", tt.name, body, tt.wantBody)
+		}
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -33,6 +35,13 @@ var (
 	settingsMutex   sync.RWMutex
 )
 
+const (
+	// Port the web UI and API listen on
+	serverPort = 3347
+	// Where torrent clients store downloaded data
+	torrentDataDir = "./torrent-data"
+)
+
 type TorrentSession struct {
 	Client   *torrent.Client
 	Torrent  *torrent.Torrent
@@ -49,6 +58,23 @@ type Settings struct {
 	EnableJackett  bool   `json:"enableJackett"`
 	JackettHost    string `json:"jackettHost"`
 	JackettApiKey  string `json:"jackettApiKey"`
+
+	TorznabIndexers []TorznabSettings `json:"torznabIndexers"`
+
+	MaxTranscodes     int                `json:"maxTranscodes"`
+	TranscodeProfiles []TranscodeProfile `json:"transcodeProfiles"`
+
+	EnableDLNA bool   `json:"enableDLNA"`
+	DLNAName   string `json:"dlnaName"`
+
+	// Address players outside the browser reach us at, e.g. https://bitplay.example.com
+	PublicBaseURL string `json:"publicBaseUrl"`
+	// Reverse proxies, by IP or CIDR, whose X-Forwarded-Host and
+	// X-Forwarded-Proto headers are believed; loopback always is
+	TrustedProxies []string `json:"trustedProxies"`
+
+	SearchCacheTTL  int `json:"searchCacheTtl"`
+	SearchCacheSize int `json:"searchCacheSize"`
 }
 
 type ProxySettings struct {
@@ -95,47 +121,73 @@ func formatSize(sizeInBytes float64) string {
 }
 
 var (
-	proxyTransport = &http.Transport{
-		// copy your existing timeouts & DialContext logic here...
-		TLSHandshakeTimeout:   10 * time.Second,
-		ResponseHeaderTimeout: 20 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
-		MaxIdleConnsPerHost:   10,
+	// Shared by every client while the proxy URL stays the same, so their
+	// connections are reused. A changed URL gets a new transport instead of
+	// rewiring one that requests may be using.
+	proxyTransport      *http.Transport
+	proxyTransportURL   string
+	proxyTransportMutex sync.Mutex
+)
+
+// Follow up to 10 redirects, keeping the original request's headers
+func followRedirectsWithHeaders(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("too many redirects")
 	}
-	proxyClient = &http.Client{
-		Transport: proxyTransport,
-		Timeout:   30 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return errors.New("too many redirects")
-			}
-			for k, vv := range via[0].Header {
-				if _, ok := req.Header[k]; !ok {
-					req.Header[k] = vv
-				}
-			}
-			return nil
-		},
+	for k, vv := range via[0].Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = vv
+		}
 	}
-)
+	return nil
+}
 
+// A new client for each call, so callers can change it without affecting others
 func createSelectiveProxyClient() *http.Client {
 	settingsMutex.RLock()
-	defer settingsMutex.RUnlock()
+	enableProxy := currentSettings.EnableProxy
+	proxyURL := currentSettings.ProxyURL
+	settingsMutex.RUnlock()
 
-	if !currentSettings.EnableProxy {
+	if !enableProxy {
 		return &http.Client{Timeout: 30 * time.Second}
 	}
-	// Reconfigure proxyTransport’s DialContext if URL changed:
-	dialer, _ := createProxyDialer(currentSettings.ProxyURL)
-	proxyTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return dialer.Dial(network, addr)
+	return &http.Client{
+		Transport:     proxyTransportFor(proxyURL),
+		Timeout:       30 * time.Second,
+		CheckRedirect: followRedirectsWithHeaders,
 	}
-	// Drop any old idle conns after reconfiguration:
-	proxyTransport.CloseIdleConnections()
+}
+
+// The transport dialing through proxyURL, replacing the old one if the URL changed
+func proxyTransportFor(proxyURL string) *http.Transport {
+	proxyTransportMutex.Lock()
+	defer proxyTransportMutex.Unlock()
 
-	return proxyClient
+	if proxyTransport != nil && proxyTransportURL == proxyURL {
+		return proxyTransport
+	}
+	if proxyTransport != nil {
+		// Requests still using the old transport finish on their connections
+		proxyTransport.CloseIdleConnections()
+	}
+
+	dialer, err := createProxyDialer(proxyURL)
+	proxyTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if err != nil {
+				return nil, err
+			}
+			return dialer.Dial(network, addr)
+		},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       30 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	}
+	proxyTransportURL = proxyURL
+	return proxyTransport
 }
 
 // Create a proxy dialer for SOCKS5
@@ -196,7 +248,7 @@ func initTorrentWithProxy() (*torrent.Client, int, error) {
 	settingsMutex.RUnlock()
 
 	config := torrent.NewDefaultClientConfig()
-	config.DefaultStorage = storage.NewFile("./torrent-data")
+	config.DefaultStorage = storage.NewFile(torrentDataDir)
 	port := getAvailablePort()
 	config.ListenPort = port
 
@@ -278,6 +330,9 @@ func init() {
 			EnableJackett:  false,
 			JackettHost:    "",
 			JackettApiKey:  "",
+
+			MaxTranscodes:     defaultMaxTranscodes,
+			TranscodeProfiles: defaultTranscodeProfiles,
 		}
 		// Create the config directory if it doesn't exist
 		if err := os.MkdirAll("config", 0755); err != nil {
@@ -320,6 +375,13 @@ func main() {
 	// Force proxy for all Go HTTP connections
 	setGlobalProxy()
 
+	// Restore playback positions and history saved by earlier runs
+	loadWatchProgress()
+	loadHistory()
+
+	// Advertise the DLNA media server if it's enabled
+	restartDLNA()
+
 	// Set up endpoint handlers
 	http.HandleFunc("/api/v1/torrent/add", addTorrentHandler)
 	http.HandleFunc("/api/v1/torrent/", torrentHandler)
@@ -335,10 +397,25 @@ func main() {
 	http.HandleFunc("/api/v1/settings/proxy", saveProxySettingsHandler)
 	http.HandleFunc("/api/v1/settings/prowlarr", saveProwlarrSettingsHandler)
 	http.HandleFunc("/api/v1/settings/jackett", saveJackettSettingsHandler)
+	http.HandleFunc("/api/v1/settings/torznab", saveTorznabSettingsHandler)
+	http.HandleFunc("/api/v1/settings/transcoding", saveTranscodingSettingsHandler)
+	http.HandleFunc("/api/v1/settings/dlna", saveDLNASettingsHandler)
+	http.HandleFunc("/api/v1/settings/cast", saveCastSettingsHandler)
+	http.HandleFunc("/api/v1/settings/search", saveSearchCacheSettingsHandler)
+	http.HandleFunc("/dlna/", dlnaHandler)
+	http.HandleFunc("/api/v1/progress", listProgressHandler)
+	http.HandleFunc("/api/v1/history", historyHandler)
+	http.HandleFunc("/api/v1/history/", historyHandler)
+	http.HandleFunc("/api/v1/search", unifiedSearchHandler)
+	http.HandleFunc("/api/v1/search/stream", streamSearchHandler)
+	http.HandleFunc("/api/v1/search/resolve", resolveSearchHandler)
+	http.HandleFunc("/api/v1/search/verify", verifyTorrentHandler)
 	http.HandleFunc("/api/v1/prowlarr/search", searchFromProwlarr)
 	http.HandleFunc("/api/v1/jackett/search", searchFromJackett)
+	http.HandleFunc("/api/v1/torznab/search", searchFromTorznab)
 	http.HandleFunc("/api/v1/prowlarr/test", testProwlarrConnection)
 	http.HandleFunc("/api/v1/jackett/test", testJackettConnection)
+	http.HandleFunc("/api/v1/torznab/test", testTorznabConnection)
 	http.HandleFunc("/api/v1/proxy/test", testProxyConnection)
 	http.HandleFunc("/api/v1/torrent/convert", convertTorrentToMagnetHandler)
 
@@ -352,8 +429,9 @@ func main() {
 	})
 
 	go cleanupSessions()
+	go flushWatchProgressLoop()
 
-	port := 3347
+	port := serverPort
 
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Attempting to start server on %s", addr)
@@ -443,10 +521,13 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 	// handle http links like Prowlarr or Jackett
 	if strings.HasPrefix(request.Magnet, "http") {
 		// Use the client that bypasses proxy for Prowlarr
-		httpClient := createSelectiveProxyClient()
-
-		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+		proxyClient := createSelectiveProxyClient()
+		httpClient := &http.Client{
+			Transport: proxyClient.Transport,
+			Timeout:   proxyClient.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		}
 
 		// Make the HTTP request to follow the Prowlarr link
@@ -499,13 +580,33 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	t, status, err := startTorrentSession(func(client *torrent.Client) (*torrent.Torrent, error) {
+		t, err := client.AddMagnet(magnet)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid magnet url: %v", err)
+		}
+		return t, nil
+	})
+	if err != nil {
+		respondWithJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	recordHistory(t, magnet)
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"sessionId": t.InfoHash().HexString()})
+}
+
+// Create a client, add a torrent to it with add and wait for its info, then
+// store it as a session keyed by info hash. On failure it returns the HTTP
+// status to report along with the error; errors from add are passed through
+// as they are, so they should say what went wrong for the user.
+func startTorrentSession(add func(*torrent.Client) (*torrent.Torrent, error)) (*torrent.Torrent, int, error) {
 	// Use the simpler, more secure proxy configuration
 	client, port, err := initTorrentWithProxy()
 	if err != nil {
 		log.Printf("Client creation error: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError,
-			map[string]string{"error": "Failed to create client with proxy"})
-		return
+		return nil, http.StatusInternalServerError, errors.New("Failed to create client with proxy")
 	}
 
 	// if we bail out before session‑storage, make sure to release both client & port
@@ -516,10 +617,9 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	t, err := client.AddMagnet(magnet)
+	t, err := add(client)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
-		return
+		return nil, http.StatusBadRequest, err
 	}
 	log.Printf("Torrent added: %s", t.InfoHash().HexString())
 
@@ -527,7 +627,7 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 	case <-t.GotInfo():
 		log.Printf("Successfully got torrent info for %s", t.InfoHash().HexString())
 	case <-time.After(3 * time.Minute):
-		respondWithJSON(w, http.StatusGatewayTimeout, map[string]string{"error": "Timeout getting info - proxy might be blocking BitTorrent traffic"})
+		return nil, http.StatusGatewayTimeout, errors.New("Timeout getting info - proxy might be blocking BitTorrent traffic")
 	}
 
 	sessionID := t.InfoHash().HexString()
@@ -546,7 +646,7 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 	// since it's now stored in the sessions map
 	client = nil
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"sessionId": sessionID})
+	return t, http.StatusOK, nil
 }
 
 // Torrent handler to serve torrent files and stream content
@@ -599,6 +699,11 @@ func torrentHandler(w http.ResponseWriter, r *http.Request) {
 	session := sessionValue.(*TorrentSession)
 	session.LastUsed = time.Now() // Update last used time
 
+	// Absolute links handed to cast receivers and playlists are signed
+	if !checkStreamSignature(w, r) {
+		return
+	}
+
 	// If there's a streaming request, handle it
 	if len(parts) > 5 && parts[5] == "stream" { // Changed from parts[4] to parts[5]
 		if len(parts) < 7 { // Changed from 6 to 7
@@ -622,86 +727,194 @@ func torrentHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		file := session.Torrent.Files()[fileIndex]
-
-		// Set appropriate Content-Type based on file extension
-		fileName := file.DisplayPath()
-		extension := strings.ToLower(filepath.Ext(fileName))
-
-		log.Printf("Streaming file: %s (type: %s)", fileName, extension)
-
-		switch extension {
-		case ".mp4":
-			w.Header().Set("Content-Type", "video/mp4")
-		case ".webm":
-			w.Header().Set("Content-Type", "video/webm")
-		case ".mkv":
-			w.Header().Set("Content-Type", "video/x-matroska")
-		case ".avi":
-			w.Header().Set("Content-Type", "video/x-msvideo")
-		case ".srt":
-			// For SRT, convert to VTT on-the-fly if requested as VTT
-			if r.URL.Query().Get("format") == "vtt" {
-				w.Header().Set("Content-Type", "text/vtt")
-				w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-
-				// Read the SRT file with size limit
-				reader := file.NewReader()
-				// Wrap with limiting reader to prevent memory issues (10MB max)
-				limitReader := io.LimitReader(reader, 10*1024*1024) // 10MB limit for subtitles
-				srtBytes, err := io.ReadAll(limitReader)
-				if err != nil {
-					http.Error(w, "Failed to read subtitle file", http.StatusInternalServerError)
-					return
-				}
+		// A specific audio track needs a remux, e.g. ?audio=1 or ?audio=jpn,
+		// and incompatible codecs a transcode, e.g. ?transcode=720p
+		if r.URL.Query().Get("audio") != "" || r.URL.Query().Get("transcode") != "" {
+			serveRemux(w, r, sessionID, fileIndex, session.Torrent.Files()[fileIndex])
+			return
+		}
 
-				// Convert from SRT to VTT
-				vttBytes := convertSRTtoVTT(srtBytes)
-				w.Write(vttBytes)
-				return
-			} else {
-				w.Header().Set("Content-Type", "text/plain")
-				w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-			}
-		case ".vtt":
-			w.Header().Set("Content-Type", "text/vtt")
-			w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-		case ".sub":
-			w.Header().Set("Content-Type", "text/plain")
-			w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-		default:
-			w.Header().Set("Content-Type", "application/octet-stream")
+		serveTorrentFile(w, r, session.Torrent.Files()[fileIndex])
+		return
+	}
+
+	// Stream by path, e.g. /api/v1/torrent/[sessionId]/file/Season 1/Episode 1.mkv
+	if len(parts) > 5 && parts[5] == "file" {
+		filePath := strings.Join(parts[6:], "/")
+		file, ok := findTorrentFile(session.Torrent, filePath)
+		if !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
 		}
 
-		// Add CORS headers for all content
-		// Stream the file
-		reader := file.NewReader()
-		// ServeContent will close the reader when done but we need to
-		// ensure it gets closed if there's a panic or other error
-		defer func() {
-			if closer, ok := reader.(io.Closer); ok {
-				closer.Close()
-				println("Closed reader***************************************")
-			}
-		}()
-		println("Serving content*****************************************")
-		http.ServeContent(w, r, fileName, time.Time{}, reader)
+		serveTorrentFile(w, r, file)
+		return
+	}
+
+	// Everything a cast receiver needs, e.g. /api/v1/torrent/[sessionId]/cast/0
+	if len(parts) > 5 && parts[5] == "cast" {
+		serveCastInfo(w, r, sessionID, session, parts)
+		return
+	}
+
+	// Download files as an archive, e.g. /api/v1/torrent/[sessionId]/archive?format=tar&files=0,1
+	if len(parts) > 5 && parts[5] == "archive" {
+		serveArchive(w, r, session)
+		return
+	}
+
+	// Poster frame and seek preview sprites, e.g. /api/v1/torrent/[sessionId]/preview/0/poster.jpg
+	if len(parts) > 5 && parts[5] == "preview" {
+		servePreview(w, r, sessionID, session, parts)
+		return
+	}
+
+	// Container and codec details, e.g. /api/v1/torrent/[sessionId]/probe/0
+	if len(parts) > 5 && parts[5] == "probe" {
+		serveProbe(w, r, session, parts)
+		return
+	}
+
+	// M3U playlist of every video, e.g. /api/v1/torrent/[sessionId]/playlist.m3u
+	if len(parts) > 5 && parts[5] == "playlist.m3u" {
+		servePlaylist(w, r, sessionID, session)
+		return
+	}
+
+	// Playback position reported by the player, e.g. /api/v1/torrent/[sessionId]/progress/0
+	if len(parts) > 5 && parts[5] == "progress" {
+		saveProgressHandler(w, r, session, parts)
 		return
 	}
 
 	// If we get here, just return file list
+	torrentFiles := session.Torrent.Files()
+	paths := make([]string, len(torrentFiles))
+	for i, file := range torrentFiles {
+		paths[i] = file.DisplayPath()
+	}
+	subtitleMatches := matchSubtitlesToVideos(paths)
+	nextFiles := nextVideoFiles(paths)
+	infoHash := session.Torrent.InfoHash().HexString()
+
 	var files []map[string]interface{}
-	for i, file := range session.Torrent.Files() {
-		files = append(files, map[string]interface{}{
+	for i, file := range torrentFiles {
+		entry := map[string]interface{}{
 			"index": i,
 			"name":  file.DisplayPath(),
 			"size":  file.Length(),
-		})
+			"url":   torrentFileURL(sessionID, paths[i]),
+		}
+
+		if lang, ok := parseSubtitleLanguage(paths[i]); ok && isSubtitleFile(paths[i]) {
+			entry["language"] = lang.Code
+			entry["languageName"] = lang.Name
+		}
+
+		// Link the subtitles that belong to this video
+		if matched, ok := subtitleMatches[i]; ok {
+			var subtitles []map[string]interface{}
+			for _, subIndex := range matched {
+				subtitle := map[string]interface{}{
+					"index": subIndex,
+					"name":  paths[subIndex],
+					"url":   fmt.Sprintf("/api/v1/torrent/%s/stream/%d.vtt?format=vtt", sessionID, subIndex),
+				}
+				if strings.ToLower(filepath.Ext(paths[subIndex])) == ".sub" {
+					subtitle["url"] = fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, subIndex)
+				}
+				if lang, ok := parseSubtitleLanguage(paths[subIndex]); ok {
+					subtitle["language"] = lang.Code
+					subtitle["languageName"] = lang.Name
+				}
+				subtitles = append(subtitles, subtitle)
+			}
+			entry["subtitles"] = subtitles
+		}
+
+		if contentType := videoContentType(paths[i]); contentType != "" {
+			entry["contentType"] = contentType
+		}
+
+		// Episode numbering and the video that plays after this one
+		if episode, ok := parseEpisode(paths[i]); ok && isVideoFile(paths[i]) {
+			entry["season"] = episode.Season
+			entry["episode"] = episode.Episode
+		}
+		if next, ok := nextFiles[i]; ok {
+			entry["next"] = next
+		}
+
+		if progress, ok := getWatchProgress(infoHash, i); ok {
+			entry["progress"] = map[string]interface{}{
+				"position":  progress.Position,
+				"duration":  progress.Duration,
+				"updatedAt": progress.UpdatedAt,
+			}
+		}
+
+		files = append(files, entry)
 	}
 
 	respondWithJSON(w, http.StatusOK, files)
 }
 
+// Serve a single torrent file, converting subtitles and reporting buffering state
+func serveTorrentFile(w http.ResponseWriter, r *http.Request, file *torrent.File) {
+	// Set appropriate Content-Type based on file extension
+	fileName := file.DisplayPath()
+	extension := strings.ToLower(filepath.Ext(fileName))
+
+	// Let external players like mpv and VLC see the real file name
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": path.Base(fileName),
+	}))
+
+	log.Printf("Streaming file: %s (type: %s)", fileName, extension)
+
+	switch extension {
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	case ".webm":
+		w.Header().Set("Content-Type", "video/webm")
+	case ".mkv":
+		w.Header().Set("Content-Type", "video/x-matroska")
+	case ".avi":
+		w.Header().Set("Content-Type", "video/x-msvideo")
+	case ".srt", ".vtt":
+		// Subtitles are transcoded to UTF-8, re-timed by ?offset= and
+		// converted to VTT on-the-fly if requested with ?format=vtt
+		serveSubtitle(w, r, file, extension)
+		return
+	case ".sub":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	setDLNAHeaders(w, r)
+
+	// Report buffering state for the requested range
+	offset := requestedRangeStart(r, file.Length())
+	setBufferingHeaders(w, file, offset)
+	if !awaitStreamData(w, r, file, offset) {
+		return
+	}
+
+	// Add CORS headers for all content
+	// Stream the file
+	reader := file.NewReader()
+	// ServeContent will close the reader when done but we need to
+	// ensure it gets closed if there's a panic or other error
+	defer func() {
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+	http.ServeContent(w, r, fileName, time.Time{}, reader)
+}
+
 // Add a function to convert SRT to VTT format
 func convertSRTtoVTT(srtBytes []byte) []byte {
 	srtContent := string(srtBytes)
@@ -758,6 +971,15 @@ func cleanupSessions() {
 				session.Client.Close()
 				sessions.Delete(key)
 				log.Printf("Removed unused session: %s", key)
+
+				// Previews are kept for torrents in the watch history, so
+				// they don't have to be generated again when re-added
+				historyMutex.RLock()
+				_, inHistory := history[key.(string)]
+				historyMutex.RUnlock()
+				if !inHistory {
+					removePreviews(key.(string))
+				}
 			}
 			return true
 		})
@@ -765,7 +987,7 @@ func cleanupSessions() {
 	}
 }
 
-// Test the proxy connection
+// Test Prowlarr Connection Handler
 func testProwlarrConnection(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -782,189 +1004,14 @@ func testProwlarrConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	prowlarrHost := settings.ProwlarrHost
-	prowlarrApiKey := settings.ProwlarrApiKey
-
-	if prowlarrHost == "" || prowlarrApiKey == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Prowlarr host or API key not set"})
-		return
-	}
-
-	client := createSelectiveProxyClient()
-	testURL := fmt.Sprintf("%s/api/v1/system/status", prowlarrHost)
-
-	req, err := http.NewRequest("GET", testURL, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
-	}
-
-	req.Header.Set("X-Api-Key", prowlarrApiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Prowlarr: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Prowlarr: " + err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Prowlarr returned status %d", resp.StatusCode)})
-		return
-	}
-
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Prowlarr response"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	prowlarr := &ProwlarrIndexer{Host: settings.ProwlarrHost, APIKey: settings.ProwlarrApiKey}
+	serveIndexerTest(w, r, prowlarr, prowlarr.Host != "" && prowlarr.APIKey != "")
 }
 
 // Search from Prowlarr
 func searchFromProwlarr(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Prowlarr-Host, X-Api-Key")
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
-		return
-	}
-
-	// search movies in prowlarr
-	settingsMutex.RLock()
-	prowlarrHost := currentSettings.ProwlarrHost
-	prowlarrApiKey := currentSettings.ProwlarrApiKey
-	settingsMutex.RUnlock()
-
-	if prowlarrHost == "" || prowlarrApiKey == "" {
-		http.Error(w, "Prowlarr host or API key not set", http.StatusBadRequest)
-		return
-	}
-
-	// Use the client that bypasses proxy for Prowlarr
-	client := createSelectiveProxyClient()
-
-	// Prowlarr search endpoint - looking for movie torrents
-	searchURL := fmt.Sprintf("%s/api/v1/search?query=%s&limit=10", prowlarrHost, url.QueryEscape(query))
-
-	req, err := http.NewRequest("GET", searchURL, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
-	}
-
-	req.Header.Set("X-Api-Key", prowlarrApiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Prowlarr: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Prowlarr: " + err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Prowlarr response"})
-		return
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Prowlarr returned status %d: %s", resp.StatusCode, string(body))})
-		return
-	}
-
-	// Parse the JSON response and process the results
-	var results []map[string]interface{}
-	if err := json.Unmarshal(body, &results); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Prowlarr response"})
-		return
-	}
-
-	// Process the results to make them more usable by the frontend
-	var processedResults []map[string]interface{}
-	for _, result := range results {
-		// Get title and download URL
-		title, hasTitle := result["title"].(string)
-		downloadUrl, hasDownloadUrl := result["downloadUrl"].(string)
-
-		// Magnet URL might be present in some results
-		magnetUrl, hasMagnet := result["magnetUrl"].(string)
-
-		if !hasTitle || title == "" {
-			// Skip results without titles
-			continue
-		}
-
-		// We need at least one of download URL or magnet URL
-		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
-			continue
-		}
-
-		// Create a simplified result object with just what we need
-		processedResult := map[string]interface{}{
-			"title": title,
-		}
-
-		// Prefer magnet URLs if available directly
-		if hasMagnet && magnetUrl != "" {
-			processedResult["magnetUrl"] = magnetUrl
-			processedResult["directMagnet"] = true
-		} else if hasDownloadUrl && downloadUrl != "" {
-			processedResult["downloadUrl"] = downloadUrl
-			processedResult["directMagnet"] = false
-		}
-
-		// Include optional fields if they exist
-		if size, ok := result["size"].(float64); ok {
-			processedResult["size"] = formatSize(size)
-		}
-
-		if seeders, ok := result["seeders"].(float64); ok {
-			processedResult["seeders"] = seeders
-		}
-
-		if leechers, ok := result["leechers"].(float64); ok {
-			processedResult["leechers"] = leechers
-		}
-
-		if indexer, ok := result["indexer"].(string); ok {
-			processedResult["indexer"] = indexer
-		}
-
-		if publishDate, ok := result["publishDate"].(string); ok {
-			processedResult["publishDate"] = publishDate
-		}
-
-		if category, ok := result["category"].(string); ok {
-			processedResult["category"] = category
-		}
-
-		processedResults = append(processedResults, processedResult)
-	}
-
-	respondWithJSON(w, http.StatusOK, processedResults)
+	prowlarr := prowlarrFromSettings()
+	serveIndexerSearch(w, r, prowlarr, prowlarr.Host != "" && prowlarr.APIKey != "")
 }
 
 // Test Jackett Connection Handler
@@ -983,185 +1030,14 @@ func testJackettConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jackettHost := settings.JackettHost
-	jackettApiKey := settings.JackettApiKey
-
-	if jackettHost == "" || jackettApiKey == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Jackett host or API key not set"})
-		return
-	}
-
-	client := createSelectiveProxyClient()
-	testURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?apikey=%s", jackettHost, jackettApiKey)
-	req, err := http.NewRequest("GET", testURL, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Jackett: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Jackett: " + err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Jackett returned status %d", resp.StatusCode)})
-		return
-	}
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Jackett response"})
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	jackett := &JackettIndexer{Host: settings.JackettHost, APIKey: settings.JackettApiKey}
+	serveIndexerTest(w, r, jackett, jackett.Host != "" && jackett.APIKey != "")
 }
 
 // Search from Jackett
 func searchFromJackett(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
-		return
-	}
-
-	// search movies in jackett
-	settingsMutex.RLock()
-	jackettHost := currentSettings.JackettHost
-	jackettApiKey := currentSettings.JackettApiKey
-	settingsMutex.RUnlock()
-
-	if jackettHost == "" || jackettApiKey == "" {
-		http.Error(w, "Jackett host or API key not set", http.StatusBadRequest)
-		return
-	}
-
-	// Use the client that bypasses proxy for Jackett
-	client := createSelectiveProxyClient()
-
-	// Jackett search endpoint - looking for movie torrents
-	searchURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?Query=%s&apikey=%s", jackettHost, url.QueryEscape(query), jackettApiKey)
-
-	req, err := http.NewRequest("GET", searchURL, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Jackett: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Jackett: " + err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Jackett response"})
-		return
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Jackett returned status %d: %s", resp.StatusCode, string(body))})
-		return
-	}
-
-	var jacketResponse struct {
-		Results []map[string]interface{} `json:"Results"`
-	}
-
-	// Parse the JSON response and process the results
-	if err := json.Unmarshal(body, &jacketResponse); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Jackett response"})
-		return
-	}
-
-	// Process the results to make them more usable by the frontend
-	var processedResults []map[string]interface{}
-	for _, result := range jacketResponse.Results {
-		// Get title and download URL
-		title, hasTitle := result["Title"].(string)
-		downloadUrl, hasDownloadUrl := result["Link"].(string)
-
-		// Magnet URL might be present in some results
-		magnetUrl, hasMagnet := result["MagnetUri"].(string)
-
-		if !hasTitle || title == "" {
-			// Skip results without titles
-			continue
-		}
-
-		// We need at least one of download URL or magnet URL
-		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
-			continue
-		}
-
-		// Create a simplified result object with just what we need
-		processedResult := map[string]interface{}{
-			"title": title,
-		}
-
-		// Prefer magnet URLs if available directly
-		if hasMagnet && magnetUrl != "" && strings.HasPrefix(magnetUrl, "magnet:") {
-			processedResult["magnetUrl"] = magnetUrl
-			processedResult["directMagnet"] = true
-		} else if hasDownloadUrl && downloadUrl != "" {
-			processedResult["downloadUrl"] = downloadUrl
-			processedResult["directMagnet"] = false
-		}
-
-		// Include optional fields if they exist
-		if size, ok := result["Size"].(float64); ok {
-			processedResult["size"] = formatSize(size)
-		}
-
-		if seeders, ok := result["Seeders"].(float64); ok {
-			processedResult["seeders"] = seeders
-		}
-
-		if leechers, ok := result["Peers"].(float64); ok {
-			processedResult["leechers"] = leechers
-		}
-
-		if indexer, ok := result["Tracker"].(string); ok {
-			processedResult["indexer"] = indexer
-		}
-
-		if publishDate, ok := result["PublishDate"].(string); ok {
-			processedResult["publishDate"] = publishDate
-		}
-
-		if category, ok := result["category"].(string); ok {
-			processedResult["category"] = category
-		}
-
-		processedResults = append(processedResults, processedResult)
-	}
-
-	respondWithJSON(w, http.StatusOK, processedResults)
+	jackett := jackettFromSettings()
+	serveIndexerSearch(w, r, jackett, jackett.Host != "" && jackett.APIKey != "")
 }
 
 // Test Proxy Connection Handler
@@ -1291,6 +1167,9 @@ func saveProxySettingsHandler(w http.ResponseWriter, r *http.Request) {
 	println("Proxy settings saved successfully")
 
 	setGlobalProxy()
+	if newSettings.EnableProxy {
+		closeHealthCheckDHT()
+	}
 
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Proxy settings saved successfully"})
 }
@@ -1324,6 +1203,9 @@ func saveProwlarrSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Cached results may be from the old indexer
+	clearSearchCache()
+
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Prowlarr settings saved successfully"})
 }
 
@@ -1356,6 +1238,9 @@ func saveJackettSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Cached results may be from the old indexer
+	clearSearchCache()
+
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Jackett settings saved successfully"})
 }
 
@@ -1409,6 +1294,13 @@ func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"magnet": metainfoMagnet(mi),
+	})
+}
+
+// Magnet link for a torrent file, with its name and trackers
+func metainfoMagnet(mi *metainfo.MetaInfo) string {
 	// Get info hash
 	infoHash := mi.HashInfoBytes().String()
 
@@ -1427,8 +1319,5 @@ func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 			magnet += fmt.Sprintf("&tr=%s", url.QueryEscape(tracker))
 		}
 	}
-
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"magnet": magnet,
-	})
+	return magnet
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestSelectiveProxyClient(t *testing.T) {
+	setTestProxy(t, true, "socks5://127.0.0.1:1080")
+
+	// Callers set their own redirect policy while others use the proxy
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := createSelectiveProxyClient()
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}()
+	}
+	wg.Wait()
+
+	first, second := createSelectiveProxyClient(), createSelectiveProxyClient()
+	if first == second {
+		t.Errorf("clients are shared between calls")
+	}
+	if first.Transport != second.Transport {
+		t.Errorf("transport is not reused for the same proxy")
+	}
+	if first.CheckRedirect == nil {
+		t.Errorf("proxy client doesn't limit redirects")
+	}
+}
+
+func TestSelectiveProxyClientSettingsChange(t *testing.T) {
+	setTestProxy(t, true, "socks5://127.0.0.1:1080")
+	before := createSelectiveProxyClient()
+	beforeTransport := before.Transport.(*http.Transport)
+	before.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	before.Timeout = 0
+
+	setTestProxy(t, true, "socks5://127.0.0.1:1081")
+	after := createSelectiveProxyClient()
+	if after == before || after.Transport == before.Transport {
+		t.Fatalf("client or transport shared across a proxy change")
+	}
+	if after.Timeout == 0 {
+		t.Errorf("new client has the timeout another caller cleared")
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if err := after.CheckRedirect(req, []*http.Request{req}); err == http.ErrUseLastResponse {
+		t.Errorf("new client has the redirect policy another caller set")
+	}
+	// The old client keeps dialing through the proxy it was created with
+	if before.Transport != beforeTransport || beforeTransport.DialContext == nil {
+		t.Errorf("old client's transport was rewired")
+	}
+
+	setTestProxy(t, false, "")
+	if direct := createSelectiveProxyClient(); direct.Transport != nil || direct.CheckRedirect != nil {
+		t.Errorf("proxy client returned while the proxy is disabled")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Video extensions and their content types
+var videoExtensions = map[string]string{
+	".mp4":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+}
+
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".vtt": true,
+	".sub": true,
+}
+
+// Episode patterns, most specific first
+var (
+	seasonEpisodePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})`)
+	crossEpisodePattern  = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^a-z0-9]|$)`)
+	seasonFolderPattern  = regexp.MustCompile(`(?i)(?:^|/)(?:season|series|s)[ ._-]?(\d{1,2})(?:/|$)`)
+	episodeOnlyPattern   = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:e|ep|episode)[ ._-]?(\d{1,3})(?:[^a-z0-9]|$)`)
+	// Anime releases: "[Group] Show - 05 [1080p].mkv"
+	absoluteEpisodePattern = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:\s|\[|\(|\.|$)`)
+)
+
+// Season and episode numbers parsed from a file path. Season is 0 when unknown.
+type episodeNumber struct {
+	Season  int
+	Episode int
+}
+
+func isVideoFile(name string) bool {
+	return videoContentType(name) != ""
+}
+
+func videoContentType(name string) string {
+	return videoExtensions[strings.ToLower(path.Ext(name))]
+}
+
+func isSubtitleFile(name string) bool {
+	return subtitleExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// File name without directory and extension
+func fileStem(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// Parse SxxEyy, 1x02, "Episode 5" or anime style "- 05" numbering from a file path
+func parseEpisode(filePath string) (episodeNumber, bool) {
+	base := fileStem(filePath)
+
+	if m := seasonEpisodePattern.FindStringSubmatch(base); m != nil {
+		return episodeNumber{Season: atoiOrZero(m[1]), Episode: atoiOrZero(m[2])}, true
+	}
+	if m := crossEpisodePattern.FindStringSubmatch(base); m != nil {
+		return episodeNumber{Season: atoiOrZero(m[1]), Episode: atoiOrZero(m[2])}, true
+	}
+
+	// Without an explicit season, fall back to a "Season 2" folder if there is one
+	season := 0
+	if m := seasonFolderPattern.FindStringSubmatch(path.Dir(filePath)); m != nil {
+		season = atoiOrZero(m[1])
+	}
+	if m := episodeOnlyPattern.FindStringSubmatch(base); m != nil {
+		return episodeNumber{Season: season, Episode: atoiOrZero(m[1])}, true
+	}
+	if m := absoluteEpisodePattern.FindStringSubmatch(base); m != nil {
+		return episodeNumber{Season: season, Episode: atoiOrZero(m[1])}, true
+	}
+	return episodeNumber{}, false
+}
+
+func atoiOrZero(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Compare names so that "Episode 2" sorts before "Episode 10"
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		aDigits := leadingDigits(a)
+		bDigits := leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNum := strings.TrimLeft(aDigits, "0")
+			bNum := strings.TrimLeft(bDigits, "0")
+			if len(aNum) != len(bNum) {
+				return len(aNum) < len(bNum)
+			}
+			if aNum != bNum {
+				return aNum < bNum
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
+
+// Sort file paths in episode order. Files with episode numbers come first,
+// and anything else follows in natural name order.
+func episodeLess(a, b string) bool {
+	aEpisode, aOk := parseEpisode(a)
+	bEpisode, bOk := parseEpisode(b)
+	if aOk != bOk {
+		return aOk
+	}
+	if aOk && aEpisode != bEpisode {
+		if aEpisode.Season != bEpisode.Season {
+			return aEpisode.Season < bEpisode.Season
+		}
+		return aEpisode.Episode < bEpisode.Episode
+	}
+	return naturalLess(a, b)
+}
+
+// Map each video's index to the video that plays after it, in episode order
+func nextVideoFiles(paths []string) map[int]int {
+	var videos []int
+	for i, filePath := range paths {
+		if isVideoFile(filePath) {
+			videos = append(videos, i)
+		}
+	}
+	sort.SliceStable(videos, func(a, b int) bool {
+		return episodeLess(paths[videos[a]], paths[videos[b]])
+	})
+
+	next := make(map[int]int)
+	for i := 0; i+1 < len(videos); i++ {
+		next[videos[i]] = videos[i+1]
+	}
+	return next
+}
+
+type mediaLanguage struct {
+	Code  string
+	Name  string
+	Alias []string
+}
+
+// Languages we recognise in file names and track metadata, by ISO 639-1/639-2 code or name
+var mediaLanguages = []mediaLanguage{
+	{"en", "English", []string{"eng"}},
+	{"es", "Spanish", []string{"spa", "espanol", "español", "castellano", "latino"}},
+	{"fr", "French", []string{"fre", "fra", "francais", "français"}},
+	{"de", "German", []string{"ger", "deu", "deutsch"}},
+	{"it", "Italian", []string{"ita", "italiano"}},
+	{"pt", "Portuguese", []string{"por", "pob", "brazilian", "portugues", "português"}},
+	{"ru", "Russian", []string{"rus"}},
+	{"ja", "Japanese", []string{"jpn", "jap"}},
+	{"zh", "Chinese", []string{"chi", "zho", "chs", "cht"}},
+	{"ko", "Korean", []string{"kor"}},
+	{"ar", "Arabic", []string{"ara"}},
+	{"nl", "Dutch", []string{"dut", "nld"}},
+	{"sv", "Swedish", []string{"swe"}},
+	{"no", "Norwegian", []string{"nor", "nob"}},
+	{"da", "Danish", []string{"dan"}},
+	{"fi", "Finnish", []string{"fin"}},
+	{"pl", "Polish", []string{"pol"}},
+	{"tr", "Turkish", []string{"tur"}},
+	{"el", "Greek", []string{"gre", "ell"}},
+	{"he", "Hebrew", []string{"heb"}},
+	{"hi", "Hindi", []string{"hin"}},
+	{"cs", "Czech", []string{"cze", "ces"}},
+	{"hu", "Hungarian", []string{"hun"}},
+	{"ro", "Romanian", []string{"rum", "ron"}},
+	{"uk", "Ukrainian", []string{"ukr"}},
+	{"vi", "Vietnamese", []string{"vie"}},
+	{"th", "Thai", []string{"tha"}},
+	{"id", "Indonesian", []string{"ind"}},
+	{"ms", "Malay", []string{"may", "msa"}},
+	{"bg", "Bulgarian", []string{"bul"}},
+	{"hr", "Croatian", []string{"hrv"}},
+	{"sr", "Serbian", []string{"srp"}},
+	{"fa", "Persian", []string{"per", "fas", "farsi"}},
+}
+
+// Find a language by its ISO 639-1 or 639-2 code, or its name
+func lookupLanguage(token string) (mediaLanguage, bool) {
+	token = strings.ToLower(token)
+	for _, lang := range mediaLanguages {
+		if token == lang.Code || token == strings.ToLower(lang.Name) {
+			return lang, true
+		}
+		for _, alias := range lang.Alias {
+			if token == alias {
+				return lang, true
+			}
+		}
+	}
+	return mediaLanguage{}, false
+}
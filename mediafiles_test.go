@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseEpisode(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   episodeNumber
+		wantOk bool
+	}{
+		{"Show.S01E02.1080p.mkv", episodeNumber{1, 2}, true},
+		{"Show s2 e105.mkv", episodeNumber{2, 105}, true},
+		{"Show.S02-E105.mkv", episodeNumber{2, 105}, true},
+		{"Show 3x07.mkv", episodeNumber{3, 7}, true},
+		{"Show/Season 2/Episode 5.mkv", episodeNumber{2, 5}, true},
+		{"Show/S03/Ep.11.mkv", episodeNumber{3, 11}, true},
+		{"[Group] Anime - 05 [1080p].mkv", episodeNumber{0, 5}, true},
+		{"[Group] Anime - 1071v2.mkv", episodeNumber{0, 1071}, true},
+		{"Show/Season 2/[Group] Anime - 12.mkv", episodeNumber{2, 12}, true},
+		// The season folder only applies without an explicit season
+		{"Show/Season 2/Show.S01E03.mkv", episodeNumber{1, 3}, true},
+		{"Movie.1920x1080.mkv", episodeNumber{}, false},
+		{"Movie.2020.mkv", episodeNumber{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseEpisode(tt.path)
+		if ok != tt.wantOk || got != tt.want {
+			t.Errorf("parseEpisode(%q) = %+v, %v; want %+v, %v", tt.path, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Episode 2", "Episode 10", true},
+		{"Episode 10", "Episode 2", false},
+		{"episode 2", "Episode 3", true},
+		{"Part 02", "Part 2b", true},
+		{"Part 007", "Part 7", false},
+		{"Part 7", "Part 007", false},
+		{"a", "ab", true},
+		{"ab", "a", false},
+		{"b1", "a2", false},
+		{"10", "9a", false},
+		{"", "a", true},
+		{"same", "same", false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestEpisodeLess(t *testing.T) {
+	paths := []string{
+		"Extras/Behind the Scenes 10.mkv",
+		"Show.S02E01.mkv",
+		"Show.S01E10.mkv",
+		"Extras/Behind the Scenes 2.mkv",
+		"Show.S01E02.mkv",
+		"Show 1x01.mkv",
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return episodeLess(paths[i], paths[j]) })
+
+	want := []string{
+		"Show 1x01.mkv",
+		"Show.S01E02.mkv",
+		"Show.S01E10.mkv",
+		"Show.S02E01.mkv",
+		"Extras/Behind the Scenes 2.mkv",
+		"Extras/Behind the Scenes 10.mkv",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("sorted = %q, want %q", paths, want)
+	}
+}
+
+func TestNextVideoFiles(t *testing.T) {
+	paths := []string{
+		"Show.S01E10.mkv",
+		"Show.S01E02.mp4",
+		"Show.S01E02.en.srt",
+		"Show.S01E01.mkv",
+		"readme.txt",
+	}
+	want := map[int]int{3: 1, 1: 0}
+	if got := nextVideoFiles(paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("nextVideoFiles = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Base URL for absolute links: the configured public base URL, or else the
+// scheme and host the client used to reach us. Reverse proxy headers are
+// only honoured from a trusted proxy, as anyone could send them.
+func requestBaseURL(r *http.Request) string {
+	settingsMutex.RLock()
+	publicBaseURL := currentSettings.PublicBaseURL
+	trustedProxies := currentSettings.TrustedProxies
+	settingsMutex.RUnlock()
+	if publicBaseURL != "" {
+		return publicBaseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if !fromTrustedProxy(r, trustedProxies) {
+		return scheme + "://" + host
+	}
+
+	if forwardedProto := r.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
+		scheme = strings.TrimSpace(strings.Split(forwardedProto, ",")[0])
+	}
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = strings.TrimSpace(strings.Split(forwardedHost, ",")[0])
+	}
+	return scheme + "://" + host
+}
+
+// Parse a trusted proxy setting: an IP address or a CIDR range
+func parseTrustedProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Whether the request came straight from loopback or a trusted proxy
+func fromTrustedProxy(r *http.Request, trustedProxies []string) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, proxy := range trustedProxies {
+		if prefix, err := parseTrustedProxy(proxy); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve an M3U playlist of every video file in a session, in episode order
+func servePlaylist(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession) {
+	var videos []string
+	for _, file := range session.Torrent.Files() {
+		if isVideoFile(file.DisplayPath()) {
+			videos = append(videos, file.DisplayPath())
+		}
+	}
+
+	if len(videos) == 0 {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "No video files in torrent"})
+		return
+	}
+
+	sort.SliceStable(videos, func(i, j int) bool {
+		return episodeLess(videos[i], videos[j])
+	})
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	for _, video := range videos {
+		fmt.Fprintf(&playlist, "#EXTINF:-1,%s\n", fileStem(video))
+		playlist.WriteString(signedStreamURL(r, torrentFileURL(sessionID, video)) + "\n")
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": session.Torrent.Name() + ".m3u",
+	}))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte(playlist.String()))
+}
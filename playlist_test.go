@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestBaseURL(t *testing.T) {
+	settingsMutex.Lock()
+	saved := currentSettings
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		currentSettings = saved
+		settingsMutex.Unlock()
+	})
+
+	tests := []struct {
+		name           string
+		publicBaseURL  string
+		trustedProxies []string
+		remoteAddr     string
+		forwarded      bool
+		want           string
+	}{
+		{"direct", "", nil, "192.0.2.1:1234", false, "http://bitplay.lan:3347"},
+		{"untrusted forwarded headers", "", nil, "192.0.2.1:1234", true, "http://bitplay.lan:3347"},
+		{"loopback proxy", "", nil, "127.0.0.1:1234", true, "https://bitplay.example.com"},
+		{"IPv6 loopback proxy", "", nil, "[::1]:1234", true, "https://bitplay.example.com"},
+		{"trusted proxy address", "", []string{"192.0.2.1"}, "192.0.2.1:1234", true, "https://bitplay.example.com"},
+		{"trusted proxy range", "", []string{"10.0.0.1", "192.0.2.0/24"}, "192.0.2.7:1234", true, "https://bitplay.example.com"},
+		{"outside the trusted range", "", []string{"192.0.2.0/24"}, "198.51.100.1:1234", true, "http://bitplay.lan:3347"},
+		{"public base URL", "https://public.example.com", nil, "127.0.0.1:1234", true, "https://public.example.com"},
+	}
+	for _, tt := range tests {
+		settingsMutex.Lock()
+		currentSettings.PublicBaseURL = tt.publicBaseURL
+		currentSettings.TrustedProxies = tt.trustedProxies
+		settingsMutex.Unlock()
+
+		r := httptest.NewRequest("GET", "http://bitplay.lan:3347/api/v1/torrent/x/playlist", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded {
+			r.Header.Set("X-Forwarded-Host", "bitplay.example.com, proxy.internal")
+			r.Header.Set("X-Forwarded-Proto", "https")
+		}
+		if got := requestBaseURL(r); got != tt.want {
+			t.Errorf("%s: requestBaseURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxy(t *testing.T) {
+	tests := []struct {
+		proxy   string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.1", "192.0.2.1/32", false},
+		{"192.0.2.9/24", "192.0.2.0/24", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"proxy.internal", "", true},
+		{"192.0.2.0/33", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrustedProxy(tt.proxy)
+		if (err != nil) != tt.wantErr || (err == nil && got.String() != tt.want) {
+			t.Errorf("parseTrustedProxy(%q) = %v, %v; want %s", tt.proxy, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Seek preview sprite layout
+const (
+	spriteColumns   = 5
+	spriteRows      = 4
+	thumbnailWidth  = 160
+	thumbnailHeight = 90
+)
+
+// Generating previews may have to download pieces all over the file
+const previewTimeout = 3 * time.Minute
+
+// Serializes generation per output directory, so concurrent requests share one ffmpeg run
+var previewLocks sync.Map
+
+// Serve a poster frame or seek preview track for a video file:
+//
+//	/api/v1/torrent/[sessionId]/preview/[index]/poster.jpg
+//	/api/v1/torrent/[sessionId]/preview/[index]/thumbnails.vtt
+//	/api/v1/torrent/[sessionId]/preview/[index]/sprite.jpg
+//
+// Results are cached next to the torrent data.
+func servePreview(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession, parts []string) {
+	if len(parts) < 8 {
+		http.Error(w, "Invalid preview path", http.StatusBadRequest)
+		return
+	}
+
+	fileIndex, err := strconv.Atoi(parts[6])
+	if err != nil || fileIndex < 0 || fileIndex >= len(session.Torrent.Files()) {
+		http.Error(w, "Invalid file index", http.StatusBadRequest)
+		return
+	}
+	if !isVideoFile(session.Torrent.Files()[fileIndex].DisplayPath()) {
+		http.Error(w, "Previews are only available for video files", http.StatusBadRequest)
+		return
+	}
+
+	dir := filepath.Join(previewsDir(session.Torrent.InfoHash().HexString()), strconv.Itoa(fileIndex))
+	ctx, cancel := context.WithTimeout(r.Context(), previewTimeout)
+	defer cancel()
+
+	var name, contentType string
+	switch parts[7] {
+	case "poster.jpg":
+		name, contentType = "poster.jpg", "image/jpeg"
+		err = withPreviewLock(filepath.Join(dir, name), func() error {
+			return generatePoster(ctx, localStreamURL(sessionID, fileIndex), dir)
+		})
+	case "thumbnails.vtt", "sprite.jpg":
+		name, contentType = parts[7], "image/jpeg"
+		if name == "thumbnails.vtt" {
+			contentType = "text/vtt; charset=utf-8"
+		}
+		err = withPreviewLock(filepath.Join(dir, "thumbnails.vtt"), func() error {
+			return generateSprite(ctx, localStreamURL(sessionID, fileIndex), dir)
+		})
+	default:
+		http.Error(w, "Unknown preview", http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, errFFmpegNotFound) {
+		respondWithJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating preview for %s: %v", session.Torrent.Files()[fileIndex].DisplayPath(), err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate preview"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, filepath.Join(dir, name))
+}
+
+// Where previews of a torrent's files are cached
+func previewsDir(infoHash string) string {
+	return filepath.Join(torrentDataDir, ".previews", infoHash)
+}
+
+// Delete the cached previews of a torrent
+func removePreviews(infoHash string) {
+	dir := previewsDir(infoHash)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Warning: Could not remove previews of %s: %v", infoHash, err)
+	}
+	previewLocks.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), dir+string(filepath.Separator)) {
+			previewLocks.Delete(key)
+		}
+		return true
+	})
+}
+
+// Run generate unless output already exists, holding a lock for that output
+func withPreviewLock(output string, generate func() error) error {
+	lock, _ := previewLocks.LoadOrStore(output, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(output); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+	return generate()
+}
+
+// Grab a single frame a tenth of the way in, past most intros and black frames
+func generatePoster(ctx context.Context, input, dir string) error {
+	duration, err := probeDuration(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, "poster.tmp.jpg")
+	defer os.Remove(tmp)
+	if output, err := runFFmpeg(ctx,
+		"-ss", formatFFmpegTime(duration/10),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", "scale=640:-2",
+		"-y", tmp,
+	); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+	return os.Rename(tmp, filepath.Join(dir, "poster.jpg"))
+}
+
+// Build a sprite sheet of evenly spaced thumbnails and a WebVTT track that
+// maps each time range to its tile, as used by player seek previews
+func generateSprite(ctx context.Context, input, dir string) error {
+	duration, err := probeDuration(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	framesDir := filepath.Join(dir, "frames")
+	if err := os.MkdirAll(framesDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(framesDir)
+
+	count := spriteColumns * spriteRows
+	interval := duration / time.Duration(count)
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+		thumbnailWidth, thumbnailHeight, thumbnailWidth, thumbnailHeight)
+
+	// One seek per frame only fetches the pieces around each timestamp,
+	// instead of decoding the whole file
+	for i := 0; i < count; i++ {
+		if output, err := runFFmpeg(ctx,
+			"-ss", formatFFmpegTime(interval*time.Duration(i)+interval/2),
+			"-i", input,
+			"-frames:v", "1",
+			"-vf", scale,
+			"-y", filepath.Join(framesDir, fmt.Sprintf("frame_%03d.jpg", i)),
+		); err != nil {
+			return fmt.Errorf("ffmpeg failed on frame %d: %v: %s", i, err, output)
+		}
+	}
+
+	if output, err := runFFmpeg(ctx,
+		"-i", filepath.Join(framesDir, "frame_%03d.jpg"),
+		"-vf", fmt.Sprintf("tile=%dx%d", spriteColumns, spriteRows),
+		"-frames:v", "1",
+		"-y", filepath.Join(dir, "sprite.jpg"),
+	); err != nil {
+		return fmt.Errorf("ffmpeg failed to tile sprite: %v: %s", err, output)
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for i := 0; i < count; i++ {
+		start := interval * time.Duration(i)
+		x := (i % spriteColumns) * thumbnailWidth
+		y := (i / spriteColumns) * thumbnailHeight
+		fmt.Fprintf(&vtt, "%s --> %s\nsprite.jpg#xywh=%d,%d,%d,%d\n\n",
+			formatCueTimestamp(start, true), formatCueTimestamp(start+interval, true),
+			x, y, thumbnailWidth, thumbnailHeight)
+	}
+
+	// The track is written last, so its presence means the sprite is complete
+	return os.WriteFile(filepath.Join(dir, "thumbnails.vtt"), []byte(vtt.String()), 0644)
+}
+
+// Format a duration as seconds for ffmpeg's -ss option
+func formatFFmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
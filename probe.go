@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Probing may have to fetch the end of the file, e.g. an MP4 moov box
+const probeTimeout = time.Minute
+
+// Don't read header boxes or elements larger than this into memory
+const maxProbeElementSize = 64 << 20
+
+var errUnsupportedContainer = errors.New("unsupported container format")
+
+// A video, audio or subtitle track. Index counts tracks of the same type,
+// matching ffmpeg's stream specifiers (e.g. 0:a:1 is audio index 1).
+type mediaTrack struct {
+	Index      int    `json:"index"`
+	Codec      string `json:"codec"`
+	Language   string `json:"language,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Default    bool   `json:"default"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+}
+
+type mediaProbe struct {
+	Container string       `json:"container"`
+	Duration  float64      `json:"duration"` // seconds
+	Bitrate   int64        `json:"bitrate,omitempty"`
+	Video     []mediaTrack `json:"video"`
+	Audio     []mediaTrack `json:"audio"`
+	Subtitles []mediaTrack `json:"subtitles"`
+	// How the browser can play the file: "direct", "remux" or "transcode"
+	Playback string   `json:"playback"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Codecs that browsers decode natively
+var (
+	browserVideoCodecs = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+	browserAudioCodecs = map[string]bool{"aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true}
+)
+
+// Report codecs, duration and tracks of a file: /api/v1/torrent/[sessionId]/probe/[index]
+func serveProbe(w http.ResponseWriter, r *http.Request, session *TorrentSession, parts []string) {
+	if len(parts) < 7 {
+		http.Error(w, "Invalid probe path", http.StatusBadRequest)
+		return
+	}
+
+	fileIndex, err := strconv.Atoi(parts[6])
+	if err != nil || fileIndex < 0 || fileIndex >= len(session.Torrent.Files()) {
+		http.Error(w, "Invalid file index", http.StatusBadRequest)
+		return
+	}
+	file := session.Torrent.Files()[fileIndex]
+
+	probe, err := probeTorrentFile(r.Context(), file)
+	if errors.Is(err, errUnsupportedContainer) {
+		respondWithJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error probing %s: %v", file.DisplayPath(), err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to probe file: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, probe)
+}
+
+// Probe a torrent file's container headers
+func probeTorrentFile(ctx context.Context, file *torrent.File) (*mediaProbe, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	reader := file.NewReader()
+	defer reader.Close()
+	return probeMedia(contextReader{ctx, reader}, file.Length())
+}
+
+// Detect the container from its magic bytes and parse its headers
+func probeMedia(r io.ReadSeeker, size int64) (*mediaProbe, error) {
+	magic := make([]byte, 12)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var probe *mediaProbe
+	var err error
+	switch {
+	case bytes.Equal(magic[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		probe, err = probeMatroska(r, size)
+	case string(magic[4:8]) == "ftyp":
+		probe, err = probeMP4(r, size)
+	default:
+		return nil, errUnsupportedContainer
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.Duration > 0 {
+		probe.Bitrate = int64(float64(size) * 8 / probe.Duration)
+	}
+	probe.Playback, probe.Warnings = playbackMode(probe)
+	return probe, nil
+}
+
+// Decide whether the browser can play the file as-is, after a remux to a
+// browser container, or only after transcoding
+func playbackMode(probe *mediaProbe) (string, []string) {
+	var warnings []string
+	for _, track := range probe.Video {
+		if !browserVideoCodecs[track.Codec] {
+			warnings = append(warnings, fmt.Sprintf("Video codec %s is not supported by most browsers", track.Codec))
+		}
+	}
+
+	// One playable audio track is enough, since audio can be selected
+	audioOk := len(probe.Audio) == 0
+	for _, track := range probe.Audio {
+		if browserAudioCodecs[track.Codec] {
+			audioOk = true
+		}
+	}
+	if !audioOk {
+		warnings = append(warnings, fmt.Sprintf("Audio codec %s is not supported by most browsers", probe.Audio[0].Codec))
+	}
+
+	switch {
+	case len(warnings) > 0:
+		return "transcode", warnings
+	case probe.Container == "mp4" || probe.Container == "webm":
+		return "direct", nil
+	default:
+		return "remux", nil
+	}
+}
+
+// MP4 parsing
+
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8", "vp09": "vp9",
+	"mp4a": "aac",
+	"ac-3": "ac3", "ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"tx3g": "mov_text",
+	"wvtt": "webvtt",
+	"c608": "eia_608",
+}
+
+type mp4Box struct {
+	Type string
+	Data []byte
+}
+
+// Walk the top level boxes to find moov, which may be at the end of the file
+func probeMP4(r io.ReadSeeker, size int64) (*mediaProbe, error) {
+	var offset int64
+	for offset < size {
+		boxType, boxSize, headerSize, err := readMP4BoxHeader(r, size-offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if boxType == "moov" {
+			if boxSize-headerSize > maxProbeElementSize {
+				return nil, fmt.Errorf("moov box too large: %d bytes", boxSize)
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return nil, err
+			}
+			return parseMP4Moov(moov)
+		}
+
+		offset += boxSize
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("no moov box found")
+}
+
+func readMP4BoxHeader(r io.Reader, remaining int64) (string, int64, int64, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, 0, err
+	}
+	boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+	boxType := string(header[4:8])
+	headerSize := int64(8)
+
+	switch boxSize {
+	case 0:
+		// Box extends to the end of the file
+		boxSize = remaining
+	case 1:
+		large := make([]byte, 8)
+		if _, err := io.ReadFull(r, large); err != nil {
+			return "", 0, 0, err
+		}
+		boxSize = int64(binary.BigEndian.Uint64(large))
+		headerSize = 16
+	}
+	if boxSize < headerSize {
+		return "", 0, 0, fmt.Errorf("invalid %q box size %d", boxType, boxSize)
+	}
+	return boxType, boxSize, headerSize, nil
+}
+
+// Split a buffer into its child boxes
+func parseMP4Boxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		boxSize := int(binary.BigEndian.Uint32(data[:4]))
+		headerSize := 8
+		if boxSize == 1 && len(data) >= 16 {
+			boxSize = int(binary.BigEndian.Uint64(data[8:16]))
+			headerSize = 16
+		} else if boxSize == 0 {
+			boxSize = len(data)
+		}
+		if boxSize < headerSize || boxSize > len(data) {
+			break
+		}
+		boxes = append(boxes, mp4Box{Type: string(data[4:8]), Data: data[headerSize:boxSize]})
+		data = data[boxSize:]
+	}
+	return boxes
+}
+
+func findMP4Box(boxes []mp4Box, path ...string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.Type != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return box, true
+		}
+		return findMP4Box(parseMP4Boxes(box.Data), path[1:]...)
+	}
+	return mp4Box{}, false
+}
+
+func parseMP4Moov(moov []byte) (*mediaProbe, error) {
+	probe := &mediaProbe{Container: "mp4", Video: []mediaTrack{}, Audio: []mediaTrack{}, Subtitles: []mediaTrack{}}
+	boxes := parseMP4Boxes(moov)
+
+	if mvhd, ok := findMP4Box(boxes, "mvhd"); ok {
+		timescale, duration := parseMP4TimescaleDuration(mvhd.Data)
+		if timescale > 0 {
+			probe.Duration = float64(duration) / float64(timescale)
+		}
+	}
+
+	for _, box := range boxes {
+		if box.Type != "trak" {
+			continue
+		}
+		trak := parseMP4Boxes(box.Data)
+
+		handler := ""
+		if hdlr, ok := findMP4Box(trak, "mdia", "hdlr"); ok && len(hdlr.Data) >= 12 {
+			handler = string(hdlr.Data[8:12])
+		}
+
+		track := mediaTrack{}
+		if tkhd, ok := findMP4Box(trak, "tkhd"); ok {
+			// Enabled flag, and 16.16 fixed point width and height at the end of the box
+			track.Default = len(tkhd.Data) >= 4 && tkhd.Data[3]&1 == 1
+			if n := len(tkhd.Data); n >= 8 {
+				track.Width = int(binary.BigEndian.Uint32(tkhd.Data[n-8:n-4]) >> 16)
+				track.Height = int(binary.BigEndian.Uint32(tkhd.Data[n-4:]) >> 16)
+			}
+		}
+		if mdhd, ok := findMP4Box(trak, "mdia", "mdhd"); ok {
+			track.Language = parseMP4Language(mdhd.Data)
+		}
+		if stsd, ok := findMP4Box(trak, "mdia", "minf", "stbl", "stsd"); ok && len(stsd.Data) >= 8 {
+			// Full box header and entry count, then the first sample entry
+			entries := parseMP4Boxes(stsd.Data[8:])
+			if len(entries) > 0 {
+				track.Codec = mp4Codecs[entries[0].Type]
+				if track.Codec == "" {
+					track.Codec = strings.TrimSpace(entries[0].Type)
+				}
+				if handler == "soun" && len(entries[0].Data) >= 28 {
+					track.Channels = int(binary.BigEndian.Uint16(entries[0].Data[16:18]))
+					track.SampleRate = int(binary.BigEndian.Uint32(entries[0].Data[24:28]) >> 16)
+				}
+			}
+		}
+
+		switch handler {
+		case "vide":
+			track.Index = len(probe.Video)
+			track.Channels, track.SampleRate = 0, 0
+			probe.Video = append(probe.Video, track)
+		case "soun":
+			track.Index = len(probe.Audio)
+			track.Width, track.Height = 0, 0
+			probe.Audio = append(probe.Audio, track)
+		case "subt", "text", "sbtl", "clcp":
+			track.Index = len(probe.Subtitles)
+			track.Width, track.Height = 0, 0
+			probe.Subtitles = append(probe.Subtitles, track)
+		}
+	}
+	return probe, nil
+}
+
+// mvhd and mdhd share a layout: version 1 uses 64-bit times and duration
+func parseMP4TimescaleDuration(data []byte) (uint32, uint64) {
+	if len(data) >= 32 && data[0] == 1 {
+		return binary.BigEndian.Uint32(data[20:24]), binary.BigEndian.Uint64(data[24:32])
+	}
+	if len(data) >= 20 {
+		return binary.BigEndian.Uint32(data[12:16]), uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	return 0, 0
+}
+
+// mdhd stores an ISO 639-2 code as three 5-bit letters
+func parseMP4Language(mdhd []byte) string {
+	offset := 20
+	if len(mdhd) > 0 && mdhd[0] == 1 {
+		offset = 32
+	}
+	if len(mdhd) < offset+2 {
+		return ""
+	}
+	packed := binary.BigEndian.Uint16(mdhd[offset : offset+2])
+	language := string([]byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	})
+	if language == "und" || strings.ContainsAny(language, "`") {
+		return ""
+	}
+	return language
+}
+
+// Matroska parsing
+
+const (
+	ebmlIDHeader         = 0x1A45DFA3
+	ebmlIDDocType        = 0x4282
+	mkvIDSegment         = 0x18538067
+	mkvIDInfo            = 0x1549A966
+	mkvIDTimecodeScale   = 0x2AD7B1
+	mkvIDDuration        = 0x4489
+	mkvIDTracks          = 0x1654AE6B
+	mkvIDTrackEntry      = 0xAE
+	mkvIDTrackType       = 0x83
+	mkvIDCodecID         = 0x86
+	mkvIDLanguage        = 0x22B59C
+	mkvIDLanguageIETF    = 0x22B59D
+	mkvIDName            = 0x536E
+	mkvIDFlagDefault     = 0x88
+	mkvIDVideo           = 0xE0
+	mkvIDPixelWidth      = 0xB0
+	mkvIDPixelHeight     = 0xBA
+	mkvIDAudio           = 0xE1
+	mkvIDSamplingFreq    = 0xB5
+	mkvIDChannels        = 0x9F
+	mkvIDCluster         = 0x1F43B675
+	mkvTrackTypeVideo    = 1
+	mkvTrackTypeAudio    = 2
+	mkvTrackTypeSubtitle = 17
+	ebmlUnknownSize      = -1
+)
+
+var matroskaCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG2":          "mpeg2video",
+	"A_AAC":            "aac",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_DTS":            "dts",
+	"A_TRUEHD":         "truehd",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"S_TEXT/UTF8":      "subrip",
+	"S_TEXT/ASS":       "ass",
+	"S_TEXT/SSA":       "ass",
+	"S_TEXT/WEBVTT":    "webvtt",
+	"S_HDMV/PGS":       "hdmv_pgs_subtitle",
+	"S_VOBSUB":         "dvd_subtitle",
+}
+
+// Normalize a Matroska CodecID, e.g. "A_AAC/MPEG4/LC" and "A_DTS/MA" to their family
+func matroskaCodec(codecID string) string {
+	for prefix := codecID; prefix != ""; {
+		if codec, ok := matroskaCodecs[prefix]; ok {
+			return codec
+		}
+		slash := strings.LastIndex(prefix, "/")
+		if slash < 0 {
+			break
+		}
+		prefix = prefix[:slash]
+	}
+	return codecID
+}
+
+// Read the EBML header, then the segment's Info and Tracks, stopping at the first Cluster
+func probeMatroska(r io.ReadSeeker, size int64) (*mediaProbe, error) {
+	probe := &mediaProbe{Container: "mkv", Video: []mediaTrack{}, Audio: []mediaTrack{}, Subtitles: []mediaTrack{}}
+
+	id, headerSize, err := readEBMLElementHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != ebmlIDHeader || headerSize < 0 || headerSize > maxProbeElementSize {
+		return nil, errUnsupportedContainer
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	for _, element := range parseEBMLElements(header) {
+		if element.ID == ebmlIDDocType && string(element.Data) == "webm" {
+			probe.Container = "webm"
+		}
+	}
+
+	id, segmentSize, err := readEBMLElementHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvIDSegment {
+		return nil, errors.New("missing matroska segment")
+	}
+	segmentStart, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	segmentEnd := size
+	if segmentSize != ebmlUnknownSize && segmentStart+segmentSize < size {
+		segmentEnd = segmentStart + segmentSize
+	}
+
+	timecodeScale := uint64(1000000)
+	var duration float64
+	offset := segmentStart
+	for offset < segmentEnd {
+		id, elementSize, err := readEBMLElementHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if id == mkvIDCluster || elementSize == ebmlUnknownSize {
+			// Media data starts here; all the headers we need come before it
+			break
+		}
+
+		dataStart, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		if (id == mkvIDInfo || id == mkvIDTracks) && elementSize <= maxProbeElementSize {
+			data := make([]byte, elementSize)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if id == mkvIDInfo {
+				for _, element := range parseEBMLElements(data) {
+					switch element.ID {
+					case mkvIDTimecodeScale:
+						timecodeScale = element.Uint()
+					case mkvIDDuration:
+						duration = element.Float()
+					}
+				}
+			} else {
+				parseMatroskaTracks(data, probe)
+			}
+		}
+
+		offset = dataStart + elementSize
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	probe.Duration = duration * float64(timecodeScale) / 1e9
+	return probe, nil
+}
+
+func parseMatroskaTracks(data []byte, probe *mediaProbe) {
+	for _, entry := range parseEBMLElements(data) {
+		if entry.ID != mkvIDTrackEntry {
+			continue
+		}
+
+		// Matroska defaults: English, and tracks are default unless flagged otherwise
+		track := mediaTrack{Language: "eng", Default: true}
+		trackType := uint64(0)
+		for _, element := range parseEBMLElements(entry.Data) {
+			switch element.ID {
+			case mkvIDTrackType:
+				trackType = element.Uint()
+			case mkvIDCodecID:
+				track.Codec = matroskaCodec(element.String())
+			case mkvIDLanguage:
+				track.Language = element.String()
+			case mkvIDLanguageIETF:
+				// Prefer the primary subtag of the newer BCP 47 element
+				track.Language, _, _ = strings.Cut(element.String(), "-")
+			case mkvIDName:
+				track.Name = element.String()
+			case mkvIDFlagDefault:
+				track.Default = element.Uint() == 1
+			case mkvIDVideo:
+				for _, video := range parseEBMLElements(element.Data) {
+					switch video.ID {
+					case mkvIDPixelWidth:
+						track.Width = int(video.Uint())
+					case mkvIDPixelHeight:
+						track.Height = int(video.Uint())
+					}
+				}
+			case mkvIDAudio:
+				for _, audio := range parseEBMLElements(element.Data) {
+					switch audio.ID {
+					case mkvIDSamplingFreq:
+						track.SampleRate = int(audio.Float())
+					case mkvIDChannels:
+						track.Channels = int(audio.Uint())
+					}
+				}
+			}
+		}
+		if track.Language == "und" {
+			track.Language = ""
+		}
+
+		switch trackType {
+		case mkvTrackTypeVideo:
+			track.Index = len(probe.Video)
+			probe.Video = append(probe.Video, track)
+		case mkvTrackTypeAudio:
+			track.Index = len(probe.Audio)
+			probe.Audio = append(probe.Audio, track)
+		case mkvTrackTypeSubtitle:
+			track.Index = len(probe.Subtitles)
+			probe.Subtitles = append(probe.Subtitles, track)
+		}
+	}
+}
+
+type ebmlElement struct {
+	ID   uint32
+	Data []byte
+}
+
+func (e ebmlElement) Uint() uint64 {
+	var value uint64
+	for _, b := range e.Data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func (e ebmlElement) Float() float64 {
+	switch len(e.Data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(e.Data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(e.Data))
+	}
+	return 0
+}
+
+func (e ebmlElement) String() string {
+	return strings.TrimRight(string(e.Data), "\x00")
+}
+
+// Split a buffer into its child elements
+func parseEBMLElements(data []byte) []ebmlElement {
+	var elements []ebmlElement
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		id, size, err := readEBMLElementHeader(reader)
+		if err != nil || size < 0 || size > int64(reader.Len()) {
+			break
+		}
+		element := ebmlElement{ID: id, Data: make([]byte, size)}
+		reader.Read(element.Data)
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// Read an element ID (kept with its length marker, as IDs are usually written)
+// and its data size, which is ebmlUnknownSize when all value bits are set
+func readEBMLElementHeader(r io.Reader) (uint32, int64, error) {
+	idBytes, err := readEBMLVarint(r, 4)
+	if err != nil {
+		return 0, 0, err
+	}
+	var id uint32
+	for _, b := range idBytes {
+		id = id<<8 | uint32(b)
+	}
+
+	sizeBytes, err := readEBMLVarint(r, 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Strip the length marker from the size
+	sizeBytes[0] &= 0xFF >> len(sizeBytes)
+	size, allOnes := int64(0), true
+	for i, b := range sizeBytes {
+		size = size<<8 | int64(b)
+		mask := byte(0xFF)
+		if i == 0 {
+			mask = 0xFF >> len(sizeBytes)
+		}
+		if b != mask {
+			allOnes = false
+		}
+	}
+	if allOnes {
+		return id, ebmlUnknownSize, nil
+	}
+	return id, size, nil
+}
+
+// Read a variable length integer, whose length is given by the leading zero bits of its first byte
+func readEBMLVarint(r io.Reader, maxLength int) ([]byte, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return nil, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= maxLength && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("invalid EBML varint 0x%02x", first[0])
+	}
+
+	value := make([]byte, length)
+	value[0] = first[0]
+	if _, err := io.ReadFull(r, value[1:]); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
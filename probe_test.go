@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// MP4 fixtures
+
+func mp4TestBox(boxType string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(box, boxType...), data...)
+}
+
+// A box with a 64-bit size, as used for large mdat boxes
+func mp4TestLargeBox(boxType string, data []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, 1)
+	box = append(box, boxType...)
+	box = binary.BigEndian.AppendUint64(box, uint64(16+len(data)))
+	return append(box, data...)
+}
+
+func mp4TestLanguage(code string) uint16 {
+	return uint16(code[0]-0x60)<<10 | uint16(code[1]-0x60)<<5 | uint16(code[2]-0x60)
+}
+
+func mp4TestTrack(handler, codec, language string, enabled bool, width, height int, sampleEntry []byte) []byte {
+	tkhd := make([]byte, 84)
+	if enabled {
+		tkhd[3] = 1
+	}
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], 1000)
+	binary.BigEndian.PutUint16(mdhd[20:], mp4TestLanguage(language))
+
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+
+	stsd := binary.BigEndian.AppendUint64(nil, 1) // version, flags and entry count
+	stsd = append(stsd, mp4TestBox(codec, sampleEntry)...)
+
+	return mp4TestBox("trak",
+		mp4TestBox("tkhd", tkhd),
+		mp4TestBox("mdia",
+			mp4TestBox("mdhd", mdhd),
+			mp4TestBox("hdlr", hdlr),
+			mp4TestBox("minf", mp4TestBox("stbl", mp4TestBox("stsd", stsd))),
+		),
+	)
+}
+
+func mp4TestAudioEntry(channels, sampleRate int) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:], uint16(channels))
+	binary.BigEndian.PutUint32(entry[24:], uint32(sampleRate)<<16)
+	return entry
+}
+
+// An MP4 with its moov after the media data, as files not prepared for streaming have
+func mp4TestFile() []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90500)
+
+	return bytes.Join([][]byte{
+		mp4TestBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41")),
+		mp4TestLargeBox("mdat", make([]byte, 4096)),
+		mp4TestBox("moov",
+			mp4TestBox("mvhd", mvhd),
+			mp4TestTrack("vide", "avc1", "eng", true, 1920, 1080, make([]byte, 78)),
+			mp4TestTrack("soun", "mp4a", "jpn", false, 0, 0, mp4TestAudioEntry(2, 48000)),
+			mp4TestTrack("soun", "ac-3", "eng", false, 0, 0, mp4TestAudioEntry(6, 48000)),
+			mp4TestTrack("sbtl", "tx3g", "und", false, 0, 0, make([]byte, 8)),
+			mp4TestTrack("hint", "rtp ", "eng", false, 0, 0, nil),
+		),
+	}, nil)
+}
+
+func TestProbeMP4(t *testing.T) {
+	file := mp4TestFile()
+	probe, err := probeMedia(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &mediaProbe{
+		Container: "mp4",
+		Duration:  90.5,
+		Bitrate:   int64(float64(len(file)) * 8 / 90.5),
+		Video: []mediaTrack{
+			{Index: 0, Codec: "h264", Language: "eng", Default: true, Width: 1920, Height: 1080},
+		},
+		Audio: []mediaTrack{
+			{Index: 0, Codec: "aac", Language: "jpn", Channels: 2, SampleRate: 48000},
+			{Index: 1, Codec: "ac3", Language: "eng", Channels: 6, SampleRate: 48000},
+		},
+		Subtitles: []mediaTrack{
+			{Index: 0, Codec: "mov_text"},
+		},
+		Playback: "direct",
+	}
+	if !reflect.DeepEqual(probe, want) {
+		t.Errorf("probe =\n\t%+v\nwant\n\t%+v", probe, want)
+	}
+}
+
+func TestProbeMP4Truncated(t *testing.T) {
+	file := mp4TestFile()
+	moov := bytes.Index(file, []byte("moov")) - 4
+
+	// Without its moov the file can't be probed, and a cut-off moov fails to read
+	for _, size := range []int{moov, moov + 20} {
+		if _, err := probeMedia(bytes.NewReader(file[:size]), int64(size)); err == nil {
+			t.Errorf("probing the first %d bytes succeeded", size)
+		}
+	}
+}
+
+func TestParseMP4TimescaleDuration(t *testing.T) {
+	version0 := make([]byte, 20)
+	binary.BigEndian.PutUint32(version0[12:], 600)
+	binary.BigEndian.PutUint32(version0[16:], 1200)
+
+	version1 := make([]byte, 32)
+	version1[0] = 1
+	binary.BigEndian.PutUint32(version1[20:], 90000)
+	binary.BigEndian.PutUint64(version1[24:], 1<<33)
+
+	tests := []struct {
+		data          []byte
+		wantTimescale uint32
+		wantDuration  uint64
+	}{
+		{version0, 600, 1200},
+		{version1, 90000, 1 << 33},
+		{version1[:20], 0, 0},
+		{nil, 0, 0},
+	}
+	for i, tt := range tests {
+		timescale, duration := parseMP4TimescaleDuration(tt.data)
+		if timescale != tt.wantTimescale || duration != tt.wantDuration {
+			t.Errorf("%d: got %d, %d; want %d, %d", i, timescale, duration, tt.wantTimescale, tt.wantDuration)
+		}
+	}
+}
+
+// Matroska fixtures
+
+func ebmlTestElement(id uint32, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	var element []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(element) > 0 {
+			element = append(element, b)
+		}
+	}
+	// An 8-byte size: a length marker followed by 7 bytes of value
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(data)))
+	size[0] = 0x01
+	return append(append(element, size...), data...)
+}
+
+// An element of unknown size, as live streams write segments and clusters
+func ebmlTestUnknownSize(id uint32) []byte {
+	element := ebmlTestElement(id)
+	for i := len(element) - 7; i < len(element); i++ {
+		element[i] = 0xFF
+	}
+	return element
+}
+
+func ebmlTestUint(id uint32, value uint64) []byte {
+	data := binary.BigEndian.AppendUint64(nil, value)
+	for len(data) > 1 && data[0] == 0 {
+		data = data[1:]
+	}
+	return ebmlTestElement(id, data)
+}
+
+func ebmlTestFloat32(id uint32, value float32) []byte {
+	return ebmlTestElement(id, binary.BigEndian.AppendUint32(nil, math.Float32bits(value)))
+}
+
+func ebmlTestFloat64(id uint32, value float64) []byte {
+	return ebmlTestElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+func ebmlTestString(id uint32, value string) []byte {
+	return ebmlTestElement(id, []byte(value))
+}
+
+func matroskaTestFile(docType string, segment ...[]byte) []byte {
+	file := ebmlTestElement(ebmlIDHeader, ebmlTestString(ebmlIDDocType, docType))
+	file = append(file, ebmlTestUnknownSize(mkvIDSegment)...)
+	return append(file, bytes.Join(segment, nil)...)
+}
+
+func TestProbeMatroska(t *testing.T) {
+	file := matroskaTestFile("matroska",
+		ebmlTestElement(0x114D9B74, make([]byte, 32)), // SeekHead, skipped
+		ebmlTestElement(mkvIDInfo,
+			ebmlTestUint(mkvIDTimecodeScale, 1000000),
+			ebmlTestFloat64(mkvIDDuration, 1425000),
+		),
+		ebmlTestElement(mkvIDTracks,
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeVideo),
+				ebmlTestString(mkvIDCodecID, "V_MPEGH/ISO/HEVC"),
+				ebmlTestElement(mkvIDVideo,
+					ebmlTestUint(mkvIDPixelWidth, 3840),
+					ebmlTestUint(mkvIDPixelHeight, 2160),
+				),
+			),
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeAudio),
+				ebmlTestString(mkvIDCodecID, "A_DTS/MA"),
+				ebmlTestString(mkvIDLanguage, "por"),
+				ebmlTestString(mkvIDLanguageIETF, "pt-BR"),
+				ebmlTestString(mkvIDName, "Surround\x00"),
+				ebmlTestElement(mkvIDAudio,
+					ebmlTestFloat32(mkvIDSamplingFreq, 48000),
+					ebmlTestUint(mkvIDChannels, 6),
+				),
+			),
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeAudio),
+				ebmlTestString(mkvIDCodecID, "A_AAC/MPEG4/LC"),
+				ebmlTestString(mkvIDLanguage, "jpn"),
+				ebmlTestUint(mkvIDFlagDefault, 0),
+			),
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeSubtitle),
+				ebmlTestString(mkvIDCodecID, "S_TEXT/UTF8"),
+				ebmlTestString(mkvIDLanguage, "und"),
+				ebmlTestString(mkvIDName, "Forced"),
+			),
+		),
+		// Nothing after the first cluster is read, so junk here is ignored
+		ebmlTestUnknownSize(mkvIDCluster),
+		[]byte{0x00, 0x00, 0x00},
+	)
+
+	probe, err := probeMedia(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &mediaProbe{
+		Container: "mkv",
+		Duration:  1425,
+		Bitrate:   int64(float64(len(file)) * 8 / 1425),
+		Video: []mediaTrack{
+			{Index: 0, Codec: "hevc", Language: "eng", Default: true, Width: 3840, Height: 2160},
+		},
+		Audio: []mediaTrack{
+			{Index: 0, Codec: "dts", Language: "pt", Name: "Surround", Default: true, Channels: 6, SampleRate: 48000},
+			{Index: 1, Codec: "aac", Language: "jpn"},
+		},
+		Subtitles: []mediaTrack{
+			{Index: 0, Codec: "subrip", Name: "Forced", Default: true},
+		},
+		Playback: "transcode",
+		Warnings: []string{"Video codec hevc is not supported by most browsers"},
+	}
+	if !reflect.DeepEqual(probe, want) {
+		t.Errorf("probe =\n\t%+v\nwant\n\t%+v", probe, want)
+	}
+}
+
+func TestProbeWebM(t *testing.T) {
+	file := matroskaTestFile("webm",
+		ebmlTestElement(mkvIDInfo,
+			ebmlTestUint(mkvIDTimecodeScale, 1000),
+			ebmlTestFloat32(mkvIDDuration, 5000000),
+		),
+		ebmlTestElement(mkvIDTracks,
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeVideo),
+				ebmlTestString(mkvIDCodecID, "V_VP9"),
+			),
+			ebmlTestElement(mkvIDTrackEntry,
+				ebmlTestUint(mkvIDTrackType, mkvTrackTypeAudio),
+				ebmlTestString(mkvIDCodecID, "A_OPUS"),
+			),
+		),
+	)
+
+	probe, err := probeMedia(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Container != "webm" || probe.Duration != 5 || probe.Playback != "direct" ||
+		len(probe.Video) != 1 || probe.Video[0].Codec != "vp9" || len(probe.Audio) != 1 || probe.Audio[0].Codec != "opus" {
+		t.Errorf("probe = %+v", probe)
+	}
+}
+
+func TestProbeUnsupported(t *testing.T) {
+	file := append([]byte("RIFF\x00\x00\x00\x00AVI LIST"), make([]byte, 64)...)
+	if _, err := probeMedia(bytes.NewReader(file), int64(len(file))); err != errUnsupportedContainer {
+		t.Errorf("err = %v, want %v", err, errUnsupportedContainer)
+	}
+}
+
+func TestReadEBMLElementHeader(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		wantID   uint32
+		wantSize int64
+		wantErr  bool
+	}{
+		{[]byte{0x86, 0x85}, 0x86, 5, false},
+		{[]byte{0x42, 0x82, 0x40, 0x02}, 0x4282, 2, false},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x23}, ebmlIDHeader, 0x23, false},
+		{[]byte{0x18, 0x53, 0x80, 0x67, 0xFF}, mkvIDSegment, ebmlUnknownSize, false},
+		{[]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, mkvIDSegment, ebmlUnknownSize, false},
+		// IDs are at most 4 bytes long
+		{[]byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x81}, 0, 0, true},
+		{[]byte{0x42}, 0, 0, true},
+	}
+	for i, tt := range tests {
+		id, size, err := readEBMLElementHeader(bytes.NewReader(tt.data))
+		if (err != nil) != tt.wantErr || id != tt.wantID || size != tt.wantSize {
+			t.Errorf("%d: got 0x%X, %d, %v; want 0x%X, %d", i, id, size, err, tt.wantID, tt.wantSize)
+		}
+	}
+}
+
+func TestMatroskaCodec(t *testing.T) {
+	tests := map[string]string{
+		"V_MPEG4/ISO/AVC": "h264",
+		"A_AAC/MPEG4/LC":  "aac",
+		"A_DTS/MA":        "dts",
+		"A_TRUEHD":        "truehd",
+		"S_HDMV/PGS":      "hdmv_pgs_subtitle",
+		"V_UNKNOWN/X":     "V_UNKNOWN/X",
+	}
+	for codecID, want := range tests {
+		if got := matroskaCodec(codecID); got != want {
+			t.Errorf("matroskaCodec(%q) = %q, want %q", codecID, got, want)
+		}
+	}
+}
+
+func TestPlaybackMode(t *testing.T) {
+	tests := []struct {
+		container    string
+		video        string
+		audio        []string
+		want         string
+		wantWarnings int
+	}{
+		{"mp4", "h264", []string{"aac"}, "direct", 0},
+		{"webm", "vp9", []string{"opus"}, "direct", 0},
+		{"mkv", "h264", []string{"aac"}, "remux", 0},
+		// One playable track is enough, the other can be left unselected
+		{"mkv", "h264", []string{"ac3", "aac"}, "remux", 0},
+		{"mkv", "h264", []string{"ac3", "dts"}, "transcode", 1},
+		{"mp4", "hevc", []string{"eac3"}, "transcode", 2},
+		{"mkv", "h264", nil, "remux", 0},
+	}
+	for _, tt := range tests {
+		probe := &mediaProbe{Container: tt.container, Video: []mediaTrack{{Codec: tt.video}}}
+		for _, codec := range tt.audio {
+			probe.Audio = append(probe.Audio, mediaTrack{Codec: codec})
+		}
+		got, warnings := playbackMode(probe)
+		if got != tt.want || len(warnings) != tt.wantWarnings {
+			t.Errorf("%s %s %v: %s %q, want %s with %d warnings", tt.container, tt.video, tt.audio, got, warnings, tt.want, tt.wantWarnings)
+		}
+	}
+}
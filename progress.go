@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const progressFile = "config/progress.json"
+
+// The player posts its position every few seconds, so changes are written
+// to disk at most once per interval
+const progressFlushInterval = 10 * time.Second
+
+// Playback position of one file, kept across sessions and restarts
+type WatchProgress struct {
+	InfoHash  string    `json:"infoHash"`
+	FileIndex int       `json:"fileIndex"`
+	FileName  string    `json:"fileName"`
+	Position  float64   `json:"position"` // seconds
+	Duration  float64   `json:"duration"` // seconds
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+var (
+	watchProgress      = make(map[string]WatchProgress)
+	watchProgressDirty bool
+	watchProgressMutex sync.RWMutex
+	// Keeps flushes from overtaking each other on disk
+	watchProgressFlushMutex sync.Mutex
+)
+
+func progressKey(infoHash string, fileIndex int) string {
+	return fmt.Sprintf("%s/%d", infoHash, fileIndex)
+}
+
+// Write a value as indented JSON, creating its directory if needed
+func saveJSONFile(path string, value interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave half a file behind
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read a JSON file into value. A missing file leaves value untouched.
+func loadJSONFile(path string, value interface{}) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(value)
+}
+
+// Load saved playback positions from disk
+func loadWatchProgress() {
+	var saved []WatchProgress
+	if err := loadJSONFile(progressFile, &saved); err != nil {
+		log.Printf("Warning: Could not load watch progress: %v", err)
+		return
+	}
+
+	watchProgressMutex.Lock()
+	defer watchProgressMutex.Unlock()
+	for _, progress := range saved {
+		watchProgress[progressKey(progress.InfoHash, progress.FileIndex)] = progress
+	}
+	log.Printf("Loaded watch progress for %d files", len(saved))
+}
+
+// Save playback positions to disk if they changed since the last save. The
+// file is written outside the lock, so players posting positions don't wait
+// for the disk.
+func flushWatchProgress() error {
+	watchProgressFlushMutex.Lock()
+	defer watchProgressFlushMutex.Unlock()
+
+	watchProgressMutex.Lock()
+	if !watchProgressDirty {
+		watchProgressMutex.Unlock()
+		return nil
+	}
+	saved := sortedWatchProgress()
+	watchProgressDirty = false
+	watchProgressMutex.Unlock()
+
+	if err := saveJSONFile(progressFile, saved); err != nil {
+		watchProgressMutex.Lock()
+		watchProgressDirty = true
+		watchProgressMutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Periodically save changed playback positions
+func flushWatchProgressLoop() {
+	ticker := time.NewTicker(progressFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := flushWatchProgress(); err != nil {
+			log.Printf("Warning: Could not save watch progress: %v", err)
+		}
+	}
+}
+
+// All saved positions, most recently watched first (assumes mutex is already locked)
+func sortedWatchProgress() []WatchProgress {
+	list := make([]WatchProgress, 0, len(watchProgress))
+	for _, progress := range watchProgress {
+		list = append(list, progress)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.After(list[j].UpdatedAt)
+	})
+	return list
+}
+
+func getWatchProgress(infoHash string, fileIndex int) (WatchProgress, bool) {
+	watchProgressMutex.RLock()
+	defer watchProgressMutex.RUnlock()
+	progress, ok := watchProgress[progressKey(infoHash, fileIndex)]
+	return progress, ok
+}
+
+// Forget every saved position for a torrent, saving right away
+func deleteWatchProgress(infoHash string) error {
+	watchProgressMutex.Lock()
+	for key, progress := range watchProgress {
+		if progress.InfoHash == infoHash {
+			delete(watchProgress, key)
+			watchProgressDirty = true
+		}
+	}
+	watchProgressMutex.Unlock()
+	return flushWatchProgress()
+}
+
+// Store the playback position posted by the player:
+// POST /api/v1/torrent/[sessionId]/progress/[index] {"position": 123.4, "duration": 2580}
+func saveProgressHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession, parts []string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if len(parts) < 7 {
+		http.Error(w, "Invalid progress path", http.StatusBadRequest)
+		return
+	}
+	fileIndex, err := strconv.Atoi(parts[6])
+	if err != nil || fileIndex < 0 || fileIndex >= len(session.Torrent.Files()) {
+		http.Error(w, "Invalid file index", http.StatusBadRequest)
+		return
+	}
+	infoHash := session.Torrent.InfoHash().HexString()
+
+	if r.Method == http.MethodGet {
+		progress, ok := getWatchProgress(infoHash, fileIndex)
+		if !ok {
+			respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "No progress saved for this file"})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, progress)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Position float64 `json:"position"`
+		Duration float64 `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Position < 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	progress := WatchProgress{
+		InfoHash:  infoHash,
+		FileIndex: fileIndex,
+		FileName:  session.Torrent.Files()[fileIndex].DisplayPath(),
+		Position:  request.Position,
+		Duration:  request.Duration,
+		UpdatedAt: time.Now(),
+	}
+
+	if nearEndOfPlayback(progress.Position, progress.Duration) {
+		prebufferNextEpisode(session.Torrent, fileIndex)
+	}
+
+	// Saved to disk by flushWatchProgressLoop
+	watchProgressMutex.Lock()
+	watchProgress[progressKey(infoHash, fileIndex)] = progress
+	watchProgressDirty = true
+	watchProgressMutex.Unlock()
+
+	respondWithJSON(w, http.StatusOK, progress)
+}
+
+// List saved positions for "continue watching", most recent first
+func listProgressHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	watchProgressMutex.RLock()
+	defer watchProgressMutex.RUnlock()
+	respondWithJSON(w, http.StatusOK, sortedWatchProgress())
+}
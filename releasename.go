@@ -0,0 +1,140 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Details parsed from a release title such as
+// "Show.Name.S01E02.2160p.WEB-DL.DDP5.1.DV.HEVC-GROUP"
+type ReleaseInfo struct {
+	Resolution string `json:"resolution,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	HDR        string `json:"hdr,omitempty"`
+	Audio      string `json:"audio,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Season     int    `json:"season,omitempty"`
+	Episode    int    `json:"episode,omitempty"`
+	Year       int    `json:"year,omitempty"`
+}
+
+// A spelling of a release tag and the name it is reported as
+type releaseTag struct {
+	pattern *regexp.Regexp
+	name    string
+}
+
+// Match a tag as a whole word, so "dv" doesn't match inside "dvd"
+func newReleaseTag(pattern, name string) releaseTag {
+	return releaseTag{regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:` + pattern + `)(?:[^a-z0-9]|$)`), name}
+}
+
+// Tags by kind, most specific first
+var (
+	resolutionTags = []releaseTag{
+		newReleaseTag(`2160p|4k|uhd`, "2160p"),
+		newReleaseTag(`1080[pi]`, "1080p"),
+		newReleaseTag(`720p`, "720p"),
+		newReleaseTag(`576p`, "576p"),
+		newReleaseTag(`480p|sd`, "480p"),
+	}
+	sourceTags = []releaseTag{
+		newReleaseTag(`remux`, "Remux"),
+		newReleaseTag(`blu-?ray|bdrip|brrip|bd(?:25|50)?`, "BluRay"),
+		newReleaseTag(`web-?dl`, "WEB-DL"),
+		newReleaseTag(`web-?rip`, "WEBRip"),
+		newReleaseTag(`web`, "WEB"),
+		newReleaseTag(`hdtv|pdtv`, "HDTV"),
+		newReleaseTag(`dvd-?rip|dvd(?:5|9)?`, "DVD"),
+		newReleaseTag(`hd-?cam|cam-?rip|cam|telesync|hd-?ts|ts`, "CAM"),
+	}
+	codecTags = []releaseTag{
+		newReleaseTag(`[xh]\.?265|hevc`, "x265"),
+		newReleaseTag(`[xh]\.?264|avc`, "x264"),
+		newReleaseTag(`av1`, "AV1"),
+		newReleaseTag(`vp9`, "VP9"),
+		newReleaseTag(`xvid|divx`, "XviD"),
+	}
+	hdrTags = []releaseTag{
+		newReleaseTag(`dv|dovi|dolby[ .-]?vision`, "DV"),
+		newReleaseTag(`hdr10(?:\+|plus)`, "HDR10+"),
+		newReleaseTag(`hdr10`, "HDR10"),
+		newReleaseTag(`hdr`, "HDR"),
+	}
+	audioTags = []releaseTag{
+		newReleaseTag(`atmos`, "Atmos"),
+		newReleaseTag(`truehd`, "TrueHD"),
+		newReleaseTag(`dts-?hd(?:[ .-]?ma)?|dts-?x`, "DTS-HD"),
+		newReleaseTag(`dts`, "DTS"),
+		newReleaseTag(`ddp(?:[257][ .]?[01])?|dd\+|e-?ac-?3`, "DDP"),
+		newReleaseTag(`dd(?:[257][ .]?[01])?|ac-?3`, "DD"),
+		newReleaseTag(`flac`, "FLAC"),
+		newReleaseTag(`opus`, "Opus"),
+		newReleaseTag(`aac(?:[257][ .]?[01])?`, "AAC"),
+		newReleaseTag(`mp3`, "MP3"),
+	}
+)
+
+var (
+	// Season packs: "S01", "Season 2", "S01-S03"
+	seasonPackPattern     = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s|season[ ._-]?)(\d{1,2})(?:[^a-z0-9]|$)`)
+	releaseWordSeparators = regexp.MustCompile(`[^A-Za-z0-9]+`)
+	releaseYearPattern    = regexp.MustCompile(`^(?:19|20)\d{2}$`)
+	// "-GROUP" at the end, or "[Group]" at the start as anime releases do
+	releaseGroupPattern       = regexp.MustCompile(`(?i)-([a-z0-9]+)(?:\[[^\]]*\])?$`)
+	releaseGroupPrefixPattern = regexp.MustCompile(`^\[([^\]]+)\]`)
+	// Tag halves that look like a group in titles without one, e.g. "WEB-DL"
+	releaseGroupFalsePositives = map[string]bool{"dl": true, "rip": true, "ray": true, "hd": true, "ma": true}
+	// File extensions indexers sometimes leave on titles
+	releaseExtensionPattern = regexp.MustCompile(`(?i)\.(?:mkv|mp4|avi|m4v|torrent)$`)
+)
+
+// First tag of a kind found in s, or ""
+func matchReleaseTag(tags []releaseTag, s string) string {
+	for _, t := range tags {
+		if t.pattern.MatchString(s) {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// Parse the usual scene and anime naming conventions out of a release title.
+// Anything not recognised is left empty.
+func parseReleaseName(title string) ReleaseInfo {
+	title = releaseExtensionPattern.ReplaceAllString(strings.TrimSpace(title), "")
+
+	info := ReleaseInfo{
+		Resolution: matchReleaseTag(resolutionTags, title),
+		Source:     matchReleaseTag(sourceTags, title),
+		Codec:      matchReleaseTag(codecTags, title),
+		HDR:        matchReleaseTag(hdrTags, title),
+		Audio:      matchReleaseTag(audioTags, title),
+	}
+
+	if m := releaseGroupPrefixPattern.FindStringSubmatch(title); m != nil {
+		info.Group = strings.TrimSpace(m[1])
+	} else if m := releaseGroupPattern.FindStringSubmatch(title); m != nil && !releaseGroupFalsePositives[strings.ToLower(m[1])] {
+		info.Group = m[1]
+	}
+
+	if m := seasonEpisodePattern.FindStringSubmatch(title); m != nil {
+		info.Season, info.Episode = atoiOrZero(m[1]), atoiOrZero(m[2])
+	} else if m := crossEpisodePattern.FindStringSubmatch(title); m != nil {
+		info.Season, info.Episode = atoiOrZero(m[1]), atoiOrZero(m[2])
+	} else if m := seasonPackPattern.FindStringSubmatch(title); m != nil {
+		info.Season = atoiOrZero(m[1])
+	} else if m := absoluteEpisodePattern.FindStringSubmatch(title); m != nil && atoiOrZero(m[1]) < 1900 {
+		info.Episode = atoiOrZero(m[1])
+	}
+
+	// The last year that isn't the title's first word, so "2012 (2009)" is
+	// 2009. Whole words only, so "1920x1080" isn't a year.
+	for i, word := range releaseWordSeparators.Split(strings.TrimLeft(title, "[("), -1) {
+		if i > 0 && releaseYearPattern.MatchString(word) {
+			info.Year = atoiOrZero(word)
+		}
+	}
+	return info
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestParseReleaseName(t *testing.T) {
+	tests := []struct {
+		title string
+		want  ReleaseInfo
+	}{
+		{"Show.Name.S01E02.2160p.WEB-DL.DDP5.1.DV.HEVC-GROUP", ReleaseInfo{
+			Resolution: "2160p", Source: "WEB-DL", Codec: "x265", HDR: "DV", Audio: "DDP", Group: "GROUP", Season: 1, Episode: 2,
+		}},
+		{"Movie.Title.2019.1080p.BluRay.REMUX.AVC.TrueHD.Atmos.7.1-FraMeSToR", ReleaseInfo{
+			Resolution: "1080p", Source: "Remux", Codec: "x264", Audio: "Atmos", Group: "FraMeSToR", Year: 2019,
+		}},
+		{"2012 (2009) 720p BRRip x264 AAC-ETRG", ReleaseInfo{
+			Resolution: "720p", Source: "BluRay", Codec: "x264", Audio: "AAC", Group: "ETRG", Year: 2009,
+		}},
+		{"[SubsPlease] Anime Title - 1071 (1080p) [ABCD1234].mkv", ReleaseInfo{
+			Resolution: "1080p", Group: "SubsPlease", Episode: 1071,
+		}},
+		{"Show.Name.S03.1080p.AMZN.WEBRip.DDP5.1.x264-NTb", ReleaseInfo{
+			Resolution: "1080p", Source: "WEBRip", Codec: "x264", Audio: "DDP", Group: "NTb", Season: 3,
+		}},
+		{"Show Name 2x05 HDTV XviD", ReleaseInfo{
+			Source: "HDTV", Codec: "XviD", Season: 2, Episode: 5,
+		}},
+		{"Movie 2023 HDR10+ 4K WEB-DL DTS-HD MA 5.1 H.265", ReleaseInfo{
+			Resolution: "2160p", Source: "WEB-DL", Codec: "x265", HDR: "HDR10+", Audio: "DTS-HD", Year: 2023,
+		}},
+		{"Movie.1999.DVDRip.XviD.AC3-GRP", ReleaseInfo{
+			Source: "DVD", Codec: "XviD", Audio: "DD", Group: "GRP", Year: 1999,
+		}},
+		// Tag halves are not groups, and a resolution isn't a year
+		{"Movie 1920x1080 WEB-DL", ReleaseInfo{Source: "WEB-DL"}},
+		// Whole words only: "dvd" is not Dolby Vision
+		{"Old.Show.DVD.x264", ReleaseInfo{Source: "DVD", Codec: "x264"}},
+		{"", ReleaseInfo{}},
+	}
+	for _, tt := range tests {
+		if got := parseReleaseName(tt.title); got != tt.want {
+			t.Errorf("parseReleaseName(%q) =\n\t%+v, want\n\t%+v", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMatchReleaseTag(t *testing.T) {
+	tests := []struct {
+		tags []releaseTag
+		s    string
+		want string
+	}{
+		{resolutionTags, "1080i", "1080p"},
+		{resolutionTags, "UHD", "2160p"},
+		{resolutionTags, "1080", ""},
+		{sourceTags, "blu-ray", "BluRay"},
+		{sourceTags, "webcam", ""},
+		{codecTags, "h.264", "x264"},
+		{hdrTags, "Dolby.Vision", "DV"},
+		{hdrTags, "hdr10plus", "HDR10+"},
+		{audioTags, "e-ac-3", "DDP"},
+		{audioTags, "dd+", "DDP"},
+		{audioTags, "dd5.1", "DD"},
+	}
+	for _, tt := range tests {
+		if got := matchReleaseTag(tt.tags, tt.s); got != tt.want {
+			t.Errorf("matchReleaseTag(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Fragmented MP4 can be played while it is being written, without seeking back
+const fragmentedMP4Flags = "frag_keyframe+empty_moov+default_base_moof"
+
+// Find an audio track by its index among audio tracks, or by language code or name
+func selectAudioTrack(tracks []mediaTrack, selector string) (mediaTrack, bool) {
+	if index, err := strconv.Atoi(selector); err == nil {
+		for _, track := range tracks {
+			if track.Index == index {
+				return track, true
+			}
+		}
+		return mediaTrack{}, false
+	}
+
+	wanted, known := lookupLanguage(selector)
+	for _, track := range tracks {
+		if strings.EqualFold(track.Language, selector) {
+			return track, true
+		}
+		if language, ok := lookupLanguage(track.Language); ok && known && language.Code == wanted.Code {
+			return track, true
+		}
+	}
+	return mediaTrack{}, false
+}
+
+// Remux a file to fragmented MP4 with the first video track and one chosen
+// audio track (?audio=1 or ?audio=jpn), or transcode it with a profile
+// (?transcode=720p) for browsers that can't decode the source codecs:
+// /api/v1/torrent/[sessionId]/stream/[index]?audio=jpn&transcode=720p
+// Use ?start=<seconds> to begin part way in, since the output can't be seeked.
+func serveRemux(w http.ResponseWriter, r *http.Request, sessionID string, fileIndex int, file *torrent.File) {
+	query := r.URL.Query()
+
+	if _, err := findFFmpeg(); err != nil {
+		respondWithJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var profile *TranscodeProfile
+	if name := query.Get("transcode"); name != "" {
+		found, ok := findTranscodeProfile(name)
+		if !ok {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown transcode profile: " + name})
+			return
+		}
+		profile = &found
+	}
+
+	// Without a selected track, use the first audio track if there is one
+	audioMap, audioCopy := "0:a:0?", false
+	if selector := query.Get("audio"); selector != "" {
+		probe, err := probeTorrentFile(r.Context(), file)
+		if errors.Is(err, errUnsupportedContainer) {
+			respondWithJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to probe file: " + err.Error()})
+			return
+		}
+
+		track, ok := selectAudioTrack(probe.Audio, selector)
+		if !ok {
+			respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Audio track not found: " + selector})
+			return
+		}
+		log.Printf("Selected audio track %d (%s, %s) of %s", track.Index, track.Language, track.Codec, file.DisplayPath())
+		audioMap, audioCopy = fmt.Sprintf("0:a:%d", track.Index), browserAudioCodecs[track.Codec]
+	}
+
+	var args []string
+	if start := query.Get("start"); start != "" {
+		if seconds, err := strconv.ParseFloat(start, 64); err != nil || seconds < 0 {
+			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			return
+		}
+		args = append(args, "-ss", start)
+	}
+	args = append(args,
+		"-i", localStreamURL(sessionID, fileIndex),
+		"-map", "0:v:0?",
+		"-map", audioMap,
+	)
+
+	if profile != nil {
+		release, ok := acquireTranscodeSlot()
+		if !ok {
+			w.Header().Set("Retry-After", "30")
+			respondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Too many transcodes running, try again later"})
+			return
+		}
+		defer release()
+
+		log.Printf("Transcoding %s with profile %s", file.DisplayPath(), profile.Name)
+		args = append(args, profile.ffmpegArgs()...)
+	} else {
+		args = append(args, "-c:v", "copy")
+		// Copying keeps remuxing cheap, but audio the browser can't decode is converted to AAC
+		if audioCopy {
+			args = append(args, "-c:a", "copy")
+		} else {
+			args = append(args, "-c:a", "aac", "-b:a", "192k")
+		}
+	}
+	args = append(args, "-f", "mp4", "-movflags", fragmentedMP4Flags, "pipe:1")
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// ffmpeg is stopped as soon as the client disconnects
+	if err := streamFFmpeg(r.Context(), w, args...); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Error converting %s: %v", file.DisplayPath(), err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// Results resolved when no count is given, and the most per request
+	defaultResolveCount = 10
+	maxResolveCount     = 50
+	// Download links fetched at once
+	resolveConcurrency = 8
+	resolveTimeout     = 15 * time.Second
+	// Torrent files are small; anything bigger isn't one
+	maxResolvedTorrentSize = 10 << 20
+	// Download links to remember magnets for
+	maxResolvedLinks = 1000
+)
+
+// Magnets already resolved, by download link
+var (
+	resolvedLinks      = make(map[string]string)
+	resolvedLinksMutex sync.Mutex
+)
+
+// Turn an indexer download link into a magnet. Links either redirect to a
+// magnet or serve a .torrent file, which the magnet is built from.
+func resolveDownloadURL(ctx context.Context, downloadURL string) (string, error) {
+	resolvedLinksMutex.Lock()
+	magnet, ok := resolvedLinks[downloadURL]
+	resolvedLinksMutex.Unlock()
+	if ok {
+		return magnet, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid download link: %v", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	// Follow http redirects, but stop at a magnet
+	proxyClient := createSelectiveProxyClient()
+	client := &http.Client{
+		Transport: proxyClient.Transport,
+		Timeout:   proxyClient.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme == "magnet" {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch download link: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if location := resp.Header.Get("Location"); strings.HasPrefix(location, "magnet:") {
+		magnet = location
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download link returned status %d", resp.StatusCode)
+	} else {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResolvedTorrentSize))
+		if err != nil {
+			return "", fmt.Errorf("failed to read torrent file: %v", err)
+		}
+		mi, err := metainfo.Load(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("download link is not a torrent file: %v", err)
+		}
+		magnet = metainfoMagnet(mi)
+	}
+
+	resolvedLinksMutex.Lock()
+	if len(resolvedLinks) >= maxResolvedLinks {
+		resolvedLinks = make(map[string]string)
+	}
+	resolvedLinks[downloadURL] = magnet
+	resolvedLinksMutex.Unlock()
+	return magnet, nil
+}
+
+// Resolve the download links of the first count results to magnets in
+// parallel, then collapse releases that turned out to be the same torrent.
+// Returns the results and why links failed, by result title.
+func resolveSearchResults(ctx context.Context, results []SearchResult, count int) ([]SearchResult, map[string]string) {
+	resolveErrors := make(map[string]string)
+	var errorsMutex sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, resolveConcurrency)
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	resolved := make([]SearchResult, len(results))
+	copy(resolved, results)
+	for i := range resolved[:min(count, len(resolved))] {
+		result := &resolved[i]
+		if result.MagnetURL != "" || result.DownloadURL == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			magnet, err := resolveDownloadURL(ctx, result.DownloadURL)
+			if err != nil {
+				log.Printf("Error resolving %s: %v", result.Title, err)
+				errorsMutex.Lock()
+				resolveErrors[result.Title] = err.Error()
+				errorsMutex.Unlock()
+				return
+			}
+			result.MagnetURL = magnet
+			if infoHash := magnetInfoHash(magnet); infoHash != "" {
+				result.InfoHash = infoHash
+			}
+		}()
+	}
+	wg.Wait()
+
+	return collapseSearchResults(resolved), resolveErrors
+}
+
+// Flag results that are playing in a session or were watched before
+func flagKnownResults(results []SearchResult) {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+
+	for i := range results {
+		infoHash := results[i].InfoHash
+		if infoHash == "" {
+			continue
+		}
+		_, results[i].Active = sessions.Load(infoHash)
+		_, results[i].InHistory = history[infoHash]
+	}
+}
+
+// Resolve download links of search results ahead of time:
+// POST /api/v1/search/resolve with {"results": [...], "count": 10}
+//
+// Results come back with magnets and info hashes filled in, duplicates
+// collapsed and known releases flagged.
+func resolveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Results []SearchResult `json:"results"`
+		Count   int            `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if request.Count <= 0 {
+		request.Count = defaultResolveCount
+	}
+	request.Count = min(request.Count, maxResolveCount)
+
+	results, resolveErrors := resolveSearchResults(r.Context(), request.Results, request.Count)
+	flagKnownResults(results)
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"errors":  resolveErrors,
+	})
+}
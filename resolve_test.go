@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Change the proxy settings for the length of a test
+func setTestProxy(t *testing.T, enable bool, proxyURL string) {
+	t.Helper()
+	settingsMutex.Lock()
+	saved := currentSettings
+	currentSettings.EnableProxy = enable
+	currentSettings.ProxyURL = proxyURL
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		currentSettings = saved
+		settingsMutex.Unlock()
+	})
+}
+
+func TestResolveDownloadURL(t *testing.T) {
+	setTestProxy(t, false, "")
+	magnet := "magnet:?xt=urn:btih:" + testInfoHash
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			http.Redirect(w, r, "/magnet", http.StatusFound)
+		case "/magnet":
+			http.Redirect(w, r, magnet, http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := resolveDownloadURL(context.Background(), server.URL+"/download")
+			if err != nil || got != magnet {
+				t.Errorf("resolveDownloadURL = %q, %v; want %q", got, err, magnet)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := resolveDownloadURL(context.Background(), server.URL+"/missing"); err == nil {
+		t.Errorf("a missing link resolved")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long each indexer backend gets to answer a unified search
+const indexerSearchTimeout = 20 * time.Second
+
+var btihPattern = regexp.MustCompile(`(?i)xt=urn:btih:([a-z0-9]{32,40})`)
+
+// Hex info hash from a magnet link, converting base32 hashes
+func magnetInfoHash(magnet string) string {
+	m := btihPattern.FindStringSubmatch(magnet)
+	if m == nil {
+		return ""
+	}
+	hash := m[1]
+	if len(hash) == 32 {
+		decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err != nil {
+			return ""
+		}
+		return hex.EncodeToString(decoded)
+	}
+	if len(hash) != 40 {
+		return ""
+	}
+	return strings.ToLower(hash)
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Key that identifies the same release across indexers: its info hash if
+// known, otherwise its title with punctuation and case ignored
+func searchResultKey(result SearchResult) string {
+	if result.InfoHash != "" {
+		return "hash:" + result.InfoHash
+	}
+	return "title:" + strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(result.Title), " "), " ")
+}
+
+// Merge results from several indexers, keeping the best-seeded copy of each release
+func mergeSearchResults(resultSets [][]SearchResult) []SearchResult {
+	var all []SearchResult
+	for _, set := range resultSets {
+		all = append(all, set...)
+	}
+	results := collapseSearchResults(all)
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Seeders > results[j].Seeders
+	})
+	return results
+}
+
+// Keep one copy of each release, in the place the first copy was. The copy
+// kept is the one with most seeders, then one with a direct magnet.
+func collapseSearchResults(all []SearchResult) []SearchResult {
+	merged := make(map[string]int)
+	results := []SearchResult{}
+	for _, result := range all {
+		key := searchResultKey(result)
+		i, ok := merged[key]
+		if !ok {
+			merged[key] = len(results)
+			results = append(results, result)
+			continue
+		}
+
+		existing := results[i]
+		if result.Seeders > existing.Seeders || (result.Seeders == existing.Seeders && result.DirectMagnet && !existing.DirectMagnet) {
+			results[i] = result
+		}
+	}
+	return results
+}
+
+// Search every enabled indexer at once:
+// /api/v1/search?q=<query>
+//
+// Searches can be narrowed to categories or made TV or movie searches,
+// see parseSearchQuery.
+//
+// Indexers that fail or time out are reported in "errors" next to the
+// results of the others. The merged results can be paged, sorted and
+// filtered, see searchOptions.
+//
+// Each backend's results are cached for a while, see cachedSearch; pass
+// refresh=true to ask the indexers again.
+func unifiedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	options, err := parseSearchOptions(r.URL.Query(), defaultSearchLimit)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	indexers := enabledIndexers()
+	if len(indexers) == 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No indexer is enabled, configure Prowlarr, Jackett or a Torznab indexer in settings"})
+		return
+	}
+
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	resultSets := make([][]SearchResult, len(indexers))
+	cacheStatus := make([]searchCacheStatus, len(indexers))
+	searchErrors := make(map[string]string)
+	var errorsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i, indexer := range indexers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), indexerSearchTimeout)
+			defer cancel()
+
+			results, status, err := cachedSearch(ctx, indexer, query, refresh)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("%s did not answer within %s", indexer.Name(), indexerSearchTimeout)
+			}
+			if err != nil {
+				errorsMutex.Lock()
+				searchErrors[indexer.Name()] = err.Error()
+				errorsMutex.Unlock()
+				return
+			}
+			resultSets[i] = results
+			cacheStatus[i] = status
+		}()
+	}
+	wg.Wait()
+
+	names := make([]string, len(indexers))
+	cache := make(map[string]searchCacheStatus)
+	for i, indexer := range indexers {
+		names[i] = indexer.Name()
+		if _, failed := searchErrors[indexer.Name()]; !failed {
+			cache[indexer.Name()] = cacheStatus[i]
+		}
+	}
+
+	results, total := options.apply(mergeSearchResults(resultSets))
+	if options.Resolve > 0 {
+		results, _ = resolveSearchResults(r.Context(), results, options.Resolve)
+	}
+	if options.Verify > 0 {
+		verifySearchResults(r.Context(), results, options.Verify)
+	}
+	flagKnownResults(results)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"results":  results,
+		"total":    total,
+		"offset":   options.Offset,
+		"limit":    options.Limit,
+		"backends": names,
+		"errors":   searchErrors,
+		"cache":    cache,
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchCacheTTL  = 5 * time.Minute
+	defaultSearchCacheSize = 200
+)
+
+type SearchCacheSettings struct {
+	// Seconds to keep results, 0 for the default and negative to disable caching
+	SearchCacheTTL int `json:"searchCacheTtl"`
+	// Searches to keep, 0 for the default
+	SearchCacheSize int `json:"searchCacheSize"`
+}
+
+// Results of one search on one indexer
+type searchCacheEntry struct {
+	key      string
+	results  []SearchResult
+	storedAt time.Time
+}
+
+// Recently used searches, most recent at the front
+var (
+	searchCache      = list.New()
+	searchCacheIndex = make(map[string]*list.Element)
+	searchCacheMutex sync.Mutex
+)
+
+// Whether a search came from the cache, and how old it is
+type searchCacheStatus struct {
+	Hit bool `json:"hit"`
+	// Seconds since the indexer was asked
+	Age int `json:"age"`
+}
+
+func searchCacheLimits() (time.Duration, int) {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+
+	ttl := time.Duration(currentSettings.SearchCacheTTL) * time.Second
+	if currentSettings.SearchCacheTTL == 0 {
+		ttl = defaultSearchCacheTTL
+	}
+	size := currentSettings.SearchCacheSize
+	if size <= 0 {
+		size = defaultSearchCacheSize
+	}
+	return ttl, size
+}
+
+// Cache key for an indexer and search. Paging, sorting and filtering happen
+// after the indexer answers, so they don't need their own entries.
+func searchCacheKey(indexer Indexer, query SearchQuery) string {
+	query.Query = strings.ToLower(query.Query)
+	encoded, _ := json.Marshal(query)
+	return indexer.Name() + "\n" + string(encoded)
+}
+
+// Search an indexer, answering from the cache when the same search was made
+// within the TTL. With refresh set the indexer is always asked. Failed
+// searches are not cached.
+func cachedSearch(ctx context.Context, indexer Indexer, query SearchQuery, refresh bool) ([]SearchResult, searchCacheStatus, error) {
+	ttl, size := searchCacheLimits()
+	if ttl < 0 {
+		results, err := indexer.Search(ctx, query)
+		return results, searchCacheStatus{}, err
+	}
+	key := searchCacheKey(indexer, query)
+
+	if !refresh {
+		searchCacheMutex.Lock()
+		if element, ok := searchCacheIndex[key]; ok {
+			entry := element.Value.(*searchCacheEntry)
+			if age := time.Since(entry.storedAt); age < ttl {
+				searchCache.MoveToFront(element)
+				searchCacheMutex.Unlock()
+				return entry.results, searchCacheStatus{Hit: true, Age: int(age.Seconds())}, nil
+			}
+			searchCache.Remove(element)
+			delete(searchCacheIndex, key)
+		}
+		searchCacheMutex.Unlock()
+	}
+
+	results, err := indexer.Search(ctx, query)
+	if err != nil {
+		return nil, searchCacheStatus{}, err
+	}
+
+	searchCacheMutex.Lock()
+	defer searchCacheMutex.Unlock()
+	if element, ok := searchCacheIndex[key]; ok {
+		searchCache.Remove(element)
+	}
+	searchCacheIndex[key] = searchCache.PushFront(&searchCacheEntry{key: key, results: results, storedAt: time.Now()})
+	for searchCache.Len() > size {
+		oldest := searchCache.Back()
+		searchCache.Remove(oldest)
+		delete(searchCacheIndex, oldest.Value.(*searchCacheEntry).key)
+	}
+	return results, searchCacheStatus{}, nil
+}
+
+// Drop all cached searches, e.g. when indexer settings change
+func clearSearchCache() {
+	searchCacheMutex.Lock()
+	defer searchCacheMutex.Unlock()
+	searchCache.Init()
+	searchCacheIndex = make(map[string]*list.Element)
+}
+
+// Search Cache Settings Save Handler
+func saveSearchCacheSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings SearchCacheSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.SearchCacheTTL = newSettings.SearchCacheTTL
+	currentSettings.SearchCacheSize = newSettings.SearchCacheSize
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Search cache settings saved successfully"})
+}
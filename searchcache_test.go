@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// An indexer that counts its searches and answers with the query text
+type countingIndexer struct {
+	name     string
+	searches int
+	err      error
+}
+
+func (c *countingIndexer) Name() string                         { return c.name }
+func (c *countingIndexer) Test(context.Context) ([]byte, error) { return nil, nil }
+
+func (c *countingIndexer) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	c.searches++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []SearchResult{{Title: query.Query, Backend: c.name}}, nil
+}
+
+// Start with an empty cache and the given limits, restored when the test ends
+func setTestSearchCache(t *testing.T, ttl, size int) {
+	t.Helper()
+	clearSearchCache()
+	settingsMutex.Lock()
+	saved := currentSettings
+	currentSettings.SearchCacheTTL = ttl
+	currentSettings.SearchCacheSize = size
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		currentSettings = saved
+		settingsMutex.Unlock()
+		clearSearchCache()
+	})
+}
+
+// Make a cached search look older than it is
+func ageSearchCacheEntry(t *testing.T, indexer Indexer, query SearchQuery, age time.Duration) {
+	t.Helper()
+	searchCacheMutex.Lock()
+	defer searchCacheMutex.Unlock()
+	element, ok := searchCacheIndex[searchCacheKey(indexer, query)]
+	if !ok {
+		t.Fatalf("%q is not cached", query.Query)
+	}
+	element.Value.(*searchCacheEntry).storedAt = time.Now().Add(-age)
+}
+
+func TestCachedSearchTTL(t *testing.T) {
+	setTestSearchCache(t, 60, 10)
+	indexer := &countingIndexer{name: "Test"}
+	query := SearchQuery{Query: "Show", Type: "search"}
+	ctx := context.Background()
+
+	steps := []struct {
+		name         string
+		age          time.Duration // age the entry by this much first
+		query        SearchQuery
+		refresh      bool
+		wantHit      bool
+		wantSearches int
+	}{
+		{"first search", 0, query, false, false, 1},
+		{"repeated", 0, query, false, true, 1},
+		{"other case", 0, SearchQuery{Query: "SHOW", Type: "search"}, false, true, 1},
+		{"other type", 0, SearchQuery{Query: "Show", Type: "tvsearch"}, false, false, 2},
+		{"refresh", 0, query, true, false, 3},
+		{"within TTL", 59 * time.Second, query, false, true, 3},
+		{"expired", 61 * time.Second, query, false, false, 4},
+		{"cached again", 0, query, false, true, 4},
+	}
+	for _, step := range steps {
+		if step.age > 0 {
+			ageSearchCacheEntry(t, indexer, step.query, step.age)
+		}
+		results, status, err := cachedSearch(ctx, indexer, step.query, step.refresh)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if len(results) != 1 || status.Hit != step.wantHit || indexer.searches != step.wantSearches {
+			t.Errorf("%s: %d results, hit %v, %d searches; want hit %v, %d searches",
+				step.name, len(results), status.Hit, indexer.searches, step.wantHit, step.wantSearches)
+		}
+		if step.wantHit && step.age > 0 && status.Age != int(step.age.Seconds()) {
+			t.Errorf("%s: age = %d, want %d", step.name, status.Age, int(step.age.Seconds()))
+		}
+	}
+}
+
+func TestCachedSearchEviction(t *testing.T) {
+	setTestSearchCache(t, 60, 2)
+	indexer := &countingIndexer{name: "Test"}
+	ctx := context.Background()
+	search := func(text string) bool {
+		_, status, err := cachedSearch(ctx, indexer, SearchQuery{Query: text, Type: "search"}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status.Hit
+	}
+
+	search("a")
+	search("b")
+	// Using "a" makes "b" the least recently used, so "c" evicts it
+	if !search("a") {
+		t.Errorf("a was not cached")
+	}
+	search("c")
+	if !search("a") {
+		t.Errorf("a was evicted, want b evicted")
+	}
+	if search("b") {
+		t.Errorf("b is still cached with a cache size of 2")
+	}
+
+	// The same search on another indexer is its own entry
+	other := &countingIndexer{name: "Other"}
+	if _, status, _ := cachedSearch(ctx, other, SearchQuery{Query: "a", Type: "search"}, false); status.Hit {
+		t.Errorf("another indexer's search was served from the cache")
+	}
+}
+
+func TestCachedSearchDisabledAndErrors(t *testing.T) {
+	setTestSearchCache(t, -1, 10)
+	indexer := &countingIndexer{name: "Test"}
+	query := SearchQuery{Query: "Show", Type: "search"}
+	ctx := context.Background()
+
+	for range 2 {
+		if _, status, _ := cachedSearch(ctx, indexer, query, false); status.Hit {
+			t.Errorf("served from the cache while it is disabled")
+		}
+	}
+	if indexer.searches != 2 {
+		t.Errorf("%d searches with the cache disabled, want 2", indexer.searches)
+	}
+
+	setTestSearchCache(t, 60, 10)
+	failing := &countingIndexer{name: "Failing", err: errors.New("indexer down")}
+	for range 2 {
+		if _, _, err := cachedSearch(ctx, failing, query, false); err == nil {
+			t.Errorf("indexer error was not returned")
+		}
+	}
+	if failing.searches != 2 {
+		t.Errorf("failed search was cached")
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size of the unified search when none is requested. The single
+// indexer searches return all results unless asked to page, as they always
+// have.
+const defaultSearchLimit = 50
+
+// Paging, sorting and filtering applied to search results:
+//
+//	limit, offset           page through results; limit=0 returns them all
+//	sort=seeders|size|date  with order=asc|desc (default desc)
+//	minSeeders              drop poorly seeded releases
+//	minSize, maxSize        bytes or sizes like 700MB, 4.5GB
+//	include, exclude        comma-separated title keywords; all of include
+//	                        must appear and none of exclude may
+//	resolution, source,     comma-separated accepted values of the parsed
+//	codec, hdr, audio,      release name, e.g. resolution=1080p&codec=x264;
+//	group                   hdr=true or hdr=false for any or no HDR
+//	resolve                 resolve download links of the first results on
+//	                        the page to magnets, see resolveSearchResults
+//	verify                  check the live swarm of the first results on
+//	                        the page, see checkTorrentHealth
+type searchOptions struct {
+	Limit      int
+	Offset     int
+	Sort       string
+	Ascending  bool
+	MinSeeders int
+	MinSize    int64
+	MaxSize    int64
+	Include    []string
+	Exclude    []string
+	// Accepted release values by parameter name
+	Release map[string]map[string]bool
+	Resolve int
+	Verify  int
+}
+
+// Release name fields that can be filtered on. Values are normalised with
+// the same tags as titles, so codec=h264 finds x264 releases.
+var releaseFilterFields = []struct {
+	param string
+	tags  []releaseTag
+	value func(ReleaseInfo) string
+}{
+	{"resolution", resolutionTags, func(r ReleaseInfo) string { return r.Resolution }},
+	{"source", sourceTags, func(r ReleaseInfo) string { return r.Source }},
+	{"codec", codecTags, func(r ReleaseInfo) string { return r.Codec }},
+	{"hdr", hdrTags, func(r ReleaseInfo) string { return r.HDR }},
+	{"audio", audioTags, func(r ReleaseInfo) string { return r.Audio }},
+	{"group", nil, func(r ReleaseInfo) string { return r.Group }},
+}
+
+// Stand-ins for "any value" and "no value" in release filters
+const (
+	releaseFilterAny  = "*"
+	releaseFilterNone = ""
+)
+
+// Parse the search options, using defaultLimit when no limit is given
+func parseSearchOptions(query url.Values, defaultLimit int) (searchOptions, error) {
+	options := searchOptions{Limit: defaultLimit, Sort: "seeders"}
+
+	intParam := func(name string, target *int) error {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*target = n
+		}
+		return nil
+	}
+	if err := intParam("limit", &options.Limit); err != nil {
+		return options, err
+	}
+	if err := intParam("offset", &options.Offset); err != nil {
+		return options, err
+	}
+	if err := intParam("minSeeders", &options.MinSeeders); err != nil {
+		return options, err
+	}
+	if err := intParam("resolve", &options.Resolve); err != nil {
+		return options, err
+	}
+	if err := intParam("verify", &options.Verify); err != nil {
+		return options, err
+	}
+	options.Resolve = min(options.Resolve, maxResolveCount)
+	options.Verify = min(options.Verify, maxVerifyCount)
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		if sortBy != "seeders" && sortBy != "size" && sortBy != "date" {
+			return options, fmt.Errorf("invalid sort: %s (use seeders, size or date)", sortBy)
+		}
+		options.Sort = sortBy
+	}
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		options.Ascending = true
+	default:
+		return options, fmt.Errorf("invalid order: %s (use asc or desc)", order)
+	}
+
+	var err error
+	if options.MinSize, err = parseSizeParam(query.Get("minSize")); err != nil {
+		return options, fmt.Errorf("invalid minSize: %v", err)
+	}
+	if options.MaxSize, err = parseSizeParam(query.Get("maxSize")); err != nil {
+		return options, fmt.Errorf("invalid maxSize: %v", err)
+	}
+
+	options.Include = splitKeywords(query.Get("include"))
+	options.Exclude = splitKeywords(query.Get("exclude"))
+
+	for _, field := range releaseFilterFields {
+		values := splitKeywords(query.Get(field.param))
+		if len(values) == 0 {
+			continue
+		}
+		accepted := make(map[string]bool)
+		for _, value := range values {
+			switch {
+			case field.param == "hdr" && (value == "true" || value == "yes"):
+				value = releaseFilterAny
+			case field.param == "hdr" && (value == "false" || value == "no" || value == "sdr"):
+				value = releaseFilterNone
+			default:
+				if name := matchReleaseTag(field.tags, value); name != "" {
+					value = strings.ToLower(name)
+				}
+			}
+			accepted[value] = true
+		}
+		if options.Release == nil {
+			options.Release = make(map[string]map[string]bool)
+		}
+		options.Release[field.param] = accepted
+	}
+	return options, nil
+}
+
+func splitKeywords(value string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(value, ",") {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// Parse a size in bytes or with a unit, e.g. "1500000", "700MB" or "4.5 GB".
+// An empty value is 0, meaning no limit.
+func parseSizeParam(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end < 0 {
+		end = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+
+	// Accept GiB-style and bare G-style units as well
+	unitName := strings.Replace(strings.TrimSpace(value[end:]), "IB", "B", 1)
+	if len(unitName) == 1 && unitName != "B" {
+		unitName += "B"
+	}
+	unit, ok := sizeUnits[unitName]
+	if err != nil || !ok {
+		return 0, fmt.Errorf("%q is not a size", value)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// Layouts indexers use for publish dates
+var publishDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+func parsePublishDate(value string) time.Time {
+	for _, layout := range publishDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Whether a result passes the filters
+func (o searchOptions) matches(result SearchResult) bool {
+	if result.Seeders < o.MinSeeders {
+		return false
+	}
+	if o.MinSize > 0 && result.SizeBytes < o.MinSize {
+		return false
+	}
+	if o.MaxSize > 0 && (result.SizeBytes == 0 || result.SizeBytes > o.MaxSize) {
+		return false
+	}
+
+	title := strings.ToLower(result.Title)
+	for _, keyword := range o.Include {
+		if !strings.Contains(title, keyword) {
+			return false
+		}
+	}
+	for _, keyword := range o.Exclude {
+		if strings.Contains(title, keyword) {
+			return false
+		}
+	}
+
+	for _, field := range releaseFilterFields {
+		accepted, ok := o.Release[field.param]
+		if !ok {
+			continue
+		}
+		value := strings.ToLower(field.value(result.Release))
+		if !accepted[value] && !(value != releaseFilterNone && accepted[releaseFilterAny]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter, sort and page results. Returns the page and the number of
+// results that matched before paging.
+func (o searchOptions) apply(results []SearchResult) ([]SearchResult, int) {
+	filtered := []SearchResult{}
+	for _, result := range results {
+		if o.matches(result) {
+			filtered = append(filtered, result)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if o.Ascending {
+			a, b = b, a
+		}
+		switch o.Sort {
+		case "size":
+			return a.SizeBytes > b.SizeBytes
+		case "date":
+			return parsePublishDate(a.PublishDate).After(parsePublishDate(b.PublishDate))
+		default:
+			return a.Seeders > b.Seeders
+		}
+	})
+
+	total := len(filtered)
+	start := min(o.Offset, total)
+	end := total
+	if o.Limit > 0 {
+		end = min(start+o.Limit, total)
+	}
+	return filtered[start:end], total
+}
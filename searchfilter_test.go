@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    searchOptions
+		wantErr bool
+	}{
+		{"", searchOptions{Limit: defaultSearchLimit, Sort: "seeders"}, false},
+		{"limit=0&offset=20", searchOptions{Limit: 0, Offset: 20, Sort: "seeders"}, false},
+		{"limit=500", searchOptions{Limit: 500, Sort: "seeders"}, false},
+		{"sort=size&order=asc", searchOptions{Limit: defaultSearchLimit, Sort: "size", Ascending: true}, false},
+		{"minSeeders=5&minSize=700MB&maxSize=4.5GiB", searchOptions{Limit: defaultSearchLimit, Sort: "seeders", MinSeeders: 5, MinSize: 700 << 20, MaxSize: 4608 << 20}, false},
+		{"include=1080p,+WEB+&exclude=,cam", searchOptions{Limit: defaultSearchLimit, Sort: "seeders", Include: []string{"1080p", "web"}, Exclude: []string{"cam"}}, false},
+		{"resolve=1000&verify=3", searchOptions{Limit: defaultSearchLimit, Sort: "seeders", Resolve: maxResolveCount, Verify: 3}, false},
+		{"codec=h264,HEVC&hdr=false", searchOptions{Limit: defaultSearchLimit, Sort: "seeders", Release: map[string]map[string]bool{
+			"codec": {"x264": true, "x265": true},
+			"hdr":   {releaseFilterNone: true},
+		}}, false},
+		{"hdr=true&group=GRP", searchOptions{Limit: defaultSearchLimit, Sort: "seeders", Release: map[string]map[string]bool{
+			"hdr":   {releaseFilterAny: true},
+			"group": {"grp": true},
+		}}, false},
+		{"limit=-1", searchOptions{}, true},
+		{"offset=ten", searchOptions{}, true},
+		{"sort=name", searchOptions{}, true},
+		{"order=up", searchOptions{}, true},
+		{"minSize=lots", searchOptions{}, true},
+		{"maxSize=5XB", searchOptions{}, true},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		got, err := parseSearchOptions(values, defaultSearchLimit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSearchOptions(%q) succeeded, want an error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSearchOptions(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchOptions(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	// The single indexer searches have no default limit
+	if got, _ := parseSearchOptions(url.Values{}, 0); got.Limit != 0 {
+		t.Errorf("limit = %d with no default limit, want 0", got.Limit)
+	}
+}
+
+func TestParseSizeParam(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1500000", 1500000, false},
+		{"700MB", 700 << 20, false},
+		{"4.5 GB", 4608 << 20, false},
+		{"2g", 2 << 30, false},
+		{"1TiB", 1 << 40, false},
+		{"10 kb", 10 << 10, false},
+		{"MB", 0, true},
+		{"5 parsecs", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSizeParam(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSizeParam(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSearchOptionsApply(t *testing.T) {
+	results := []SearchResult{
+		{Title: "Show.S01E01.1080p.WEB.x264-GRP", Seeders: 50, SizeBytes: 2 << 30, PublishDate: "2024-01-03T00:00:00Z"},
+		{Title: "Show.S01E01.720p.HDTV.x264-OTHER", Seeders: 80, SizeBytes: 700 << 20, PublishDate: "2024-01-01T00:00:00Z"},
+		{Title: "Show.S01E01.2160p.WEB.HDR.HEVC-GRP", Seeders: 10, SizeBytes: 8 << 30, PublishDate: "Tue, 02 Jan 2024 00:00:00 +0000"},
+		{Title: "Show.S01E01.CAM", Seeders: 200},
+	}
+	for i := range results {
+		results[i].Release = parseReleaseName(results[i].Title)
+	}
+
+	tests := []struct {
+		query     string
+		wantTotal int
+		want      []int // indexes into results, in order
+	}{
+		{"", 4, []int{3, 1, 0, 2}},
+		{"order=asc", 4, []int{2, 0, 1, 3}},
+		{"sort=size", 4, []int{2, 0, 1, 3}},
+		{"sort=date", 4, []int{0, 2, 1, 3}},
+		{"limit=2&offset=1", 4, []int{1, 0}},
+		{"limit=0&offset=3", 4, []int{2}},
+		{"offset=10", 4, []int{}},
+		{"minSeeders=20", 3, []int{3, 1, 0}},
+		{"minSize=1GB&maxSize=4GB", 1, []int{0}},
+		{"maxSize=1GB", 1, []int{1}},
+		{"include=web,grp", 2, []int{0, 2}},
+		{"exclude=cam,hdtv", 2, []int{0, 2}},
+		{"resolution=1080p,4k", 2, []int{0, 2}},
+		{"codec=h265", 1, []int{2}},
+		{"hdr=yes", 1, []int{2}},
+		{"hdr=no&source=web", 1, []int{0}},
+		{"group=grp", 2, []int{0, 2}},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		options, err := parseSearchOptions(values, defaultSearchLimit)
+		if err != nil {
+			t.Fatalf("parseSearchOptions(%q): %v", tt.query, err)
+		}
+
+		page, total := options.apply(results)
+		var got []string
+		for _, result := range page {
+			got = append(got, result.Title)
+		}
+		var want []string
+		for _, i := range tt.want {
+			want = append(want, results[i].Title)
+		}
+		if total != tt.wantTotal || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %d %v, want %d %v", tt.query, total, got, tt.wantTotal, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Newznab category IDs understood by Torznab, Prowlarr and Jackett. The
+// top-level IDs include their subcategories on most indexers.
+var searchCategories = map[string][]int{
+	"movies": {2000},
+	"tv":     {5000},
+	"anime":  {5070},
+}
+
+// Torznab search functions
+const (
+	searchTypeGeneral = "search"
+	searchTypeTV      = "tvsearch"
+	searchTypeMovie   = "movie"
+)
+
+// What to search for. Query is free text, or the show or movie title in
+// TV and movie searches.
+type SearchQuery struct {
+	Query      string
+	Type       string
+	Categories []int
+	Season     int
+	Episode    int
+	IMDbID     string // with the tt prefix
+	TMDbID     int
+	Year       int
+}
+
+var imdbIDPattern = regexp.MustCompile(`^(?:tt)?(\d{7,})$`)
+
+// Parse a search from request parameters:
+//
+//	q                          free text, or the show/movie title
+//	category                   comma-separated movies, tv, anime or numeric IDs
+//	type=tv|movie              structured search, inferred from the fields below
+//	season, episode            TV search
+//	imdbId, tmdbId, year       movie search
+func parseSearchQuery(values url.Values) (SearchQuery, error) {
+	query := SearchQuery{Query: strings.TrimSpace(values.Get("q"))}
+
+	for _, name := range strings.Split(values.Get("category"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if ids, ok := searchCategories[name]; ok {
+			query.Categories = append(query.Categories, ids...)
+		} else if id, err := strconv.Atoi(name); err == nil && id > 0 {
+			query.Categories = append(query.Categories, id)
+		} else {
+			return query, fmt.Errorf("unknown category: %s (use movies, tv, anime or a category ID)", name)
+		}
+	}
+
+	intParam := func(name string, target *int) error {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*target = n
+		}
+		return nil
+	}
+	if err := intParam("season", &query.Season); err != nil {
+		return query, err
+	}
+	if err := intParam("episode", &query.Episode); err != nil {
+		return query, err
+	}
+	if err := intParam("tmdbId", &query.TMDbID); err != nil {
+		return query, err
+	}
+	if err := intParam("year", &query.Year); err != nil {
+		return query, err
+	}
+	if imdbID := strings.ToLower(values.Get("imdbId")); imdbID != "" {
+		m := imdbIDPattern.FindStringSubmatch(imdbID)
+		if m == nil {
+			return query, fmt.Errorf("invalid imdbId: %s", imdbID)
+		}
+		query.IMDbID = "tt" + m[1]
+	}
+	if query.Episode > 0 && query.Season == 0 {
+		return query, errors.New("episode needs a season")
+	}
+
+	switch values.Get("type") {
+	case "":
+		if query.Season > 0 {
+			query.Type = searchTypeTV
+		} else if query.IMDbID != "" || query.TMDbID > 0 || query.Year > 0 {
+			query.Type = searchTypeMovie
+		} else {
+			query.Type = searchTypeGeneral
+		}
+	case "search":
+		query.Type = searchTypeGeneral
+	case "tv", "tvsearch":
+		query.Type = searchTypeTV
+	case "movie":
+		query.Type = searchTypeMovie
+	default:
+		return query, fmt.Errorf("invalid type: %s (use tv or movie)", values.Get("type"))
+	}
+
+	// Only keep the fields of the chosen search type
+	if query.Type != searchTypeTV {
+		query.Season, query.Episode = 0, 0
+	}
+	if query.Type != searchTypeMovie {
+		query.IMDbID, query.TMDbID, query.Year = "", 0, 0
+	}
+
+	// Structured searches stay within their category unless told otherwise
+	if len(query.Categories) == 0 && query.Type == searchTypeTV {
+		query.Categories = searchCategories["tv"]
+	} else if len(query.Categories) == 0 && query.Type == searchTypeMovie {
+		query.Categories = searchCategories["movies"]
+	}
+
+	if query.Query == "" && query.IMDbID == "" && query.TMDbID == 0 {
+		return query, errors.New("No search query provided")
+	}
+	return query, nil
+}
+
+// The search as plain text, for backends without structured search,
+// e.g. "Show S01E02" or "Movie 1999"
+func (q SearchQuery) Text() string {
+	text := q.Query
+	if text == "" {
+		text = q.IMDbID
+	}
+	switch {
+	case q.Season > 0 && q.Episode > 0:
+		text += fmt.Sprintf(" S%02dE%02d", q.Season, q.Episode)
+	case q.Season > 0:
+		text += fmt.Sprintf(" S%02d", q.Season)
+	case q.Year > 0:
+		text += fmt.Sprintf(" %d", q.Year)
+	}
+	return strings.TrimSpace(text)
+}
+
+// Categories as a comma-separated list, as Torznab expects them
+func (q SearchQuery) categoryList() string {
+	ids := make([]string, len(q.Categories))
+	for i, id := range q.Categories {
+		ids[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// One message of a streamed search
+type searchEvent struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// Search every enabled indexer and stream results as each one answers:
+// /api/v1/search/stream?q=<query>
+//
+// Prowlarr and Jackett are searched one tracker at a time, so fast trackers
+// show up without waiting for slow ones. Events are sent as server-sent
+// events, or as newline-delimited JSON with format=ndjson:
+//
+//	results  {backend, results, cache} with releases not sent before
+//	error    {backend, error} for a backend that failed or timed out
+//	done     the merged response of /api/v1/search, paged and sorted
+//
+// Takes the same parameters as /api/v1/search; filters apply to every
+// batch, paging and sorting only to the final results.
+func streamSearchHandler(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	options, err := parseSearchOptions(r.URL.Query(), defaultSearchLimit)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	indexers := enabledIndexers()
+	if len(indexers) == 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No indexer is enabled, configure Prowlarr, Jackett or a Torznab indexer in settings"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Streaming is not supported"})
+		return
+	}
+
+	ndjson := r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event searchEvent) {
+		if ndjson {
+			line, _ := json.Marshal(event)
+			fmt.Fprintf(w, "%s\n", line)
+		} else {
+			data, _ := json.Marshal(event.Data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+		}
+		flusher.Flush()
+	}
+
+	type searchBatch struct {
+		backend string
+		results []SearchResult
+		cache   searchCacheStatus
+		err     error
+	}
+	batches := make(chan searchBatch)
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+
+	var wg sync.WaitGroup
+	search := func(ctx context.Context, indexer Indexer) {
+		defer wg.Done()
+		results, status, err := cachedSearch(ctx, indexer, query, refresh)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%s did not answer within %s", indexer.Name(), indexerSearchTimeout)
+		}
+		batches <- searchBatch{backend: indexer.Name(), results: results, cache: status, err: err}
+	}
+	for _, indexer := range indexers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), indexerSearchTimeout)
+			defer cancel()
+
+			// Fall back to one aggregate search if the trackers can't be listed
+			sources := []Indexer{indexer}
+			if aggregate, ok := indexer.(trackerIndexer); ok {
+				trackers, err := aggregate.Trackers(ctx)
+				if err != nil {
+					log.Printf("Error listing %s trackers, searching all at once: %v", indexer.Name(), err)
+				} else if len(trackers) > 0 {
+					sources = trackers
+				}
+			}
+
+			var sourcesWG sync.WaitGroup
+			for _, source := range sources {
+				wg.Add(1)
+				sourcesWG.Add(1)
+				go func() {
+					defer sourcesWG.Done()
+					search(ctx, source)
+				}()
+			}
+			// Keep the timeout running until every tracker has answered
+			sourcesWG.Wait()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(batches)
+	}()
+
+	var backends []string
+	var resultSets [][]SearchResult
+	searchErrors := make(map[string]string)
+	cache := make(map[string]searchCacheStatus)
+	sent := make(map[string]bool)
+	for batch := range batches {
+		backends = append(backends, batch.backend)
+		if batch.err != nil {
+			searchErrors[batch.backend] = batch.err.Error()
+			send(searchEvent{"error", map[string]string{"backend": batch.backend, "error": batch.err.Error()}})
+			continue
+		}
+		resultSets = append(resultSets, batch.results)
+		cache[batch.backend] = batch.cache
+
+		fresh := []SearchResult{}
+		for _, result := range batch.results {
+			key := searchResultKey(result)
+			if !sent[key] && options.matches(result) {
+				sent[key] = true
+				fresh = append(fresh, result)
+			}
+		}
+		flagKnownResults(fresh)
+		send(searchEvent{"results", map[string]interface{}{
+			"backend": batch.backend,
+			"results": fresh,
+			"cache":   batch.cache,
+		}})
+	}
+
+	results, total := options.apply(mergeSearchResults(resultSets))
+	if options.Resolve > 0 {
+		results, _ = resolveSearchResults(r.Context(), results, options.Resolve)
+	}
+	if options.Verify > 0 {
+		verifySearchResults(r.Context(), results, options.Verify)
+	}
+	flagKnownResults(results)
+	send(searchEvent{"done", map[string]interface{}{
+		"results":  results,
+		"total":    total,
+		"offset":   options.Offset,
+		"limit":    options.Limit,
+		"backends": backends,
+		"errors":   searchErrors,
+		"cache":    cache,
+	}})
+}
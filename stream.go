@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Headers that report how much of the requested file is buffered
+const (
+	downloadPercentHeader = "X-Download-Percent"
+	contiguousBytesHeader = "X-Contiguous-Bytes-Available"
+)
+
+// How long nowait mode waits for the first requested piece by default
+const defaultNoWaitDeadline = 2 * time.Second
+
+// Fetch the next episode once playback is this close to the end
+const (
+	nextEpisodePrebufferBytes = 16 << 20
+	nextEpisodeRemaining      = 2 * time.Minute
+	nextEpisodeFraction       = 0.9
+)
+
+// Adapts a torrent reader so blocked reads are abandoned when ctx is done,
+// e.g. when the HTTP client disconnects
+type contextReader struct {
+	ctx    context.Context
+	reader torrent.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	return c.reader.ReadContext(c.ctx, p)
+}
+
+func (c contextReader) Seek(offset int64, whence int) (int64, error) {
+	return c.reader.Seek(offset, whence)
+}
+
+// Offset of the first byte a request asks for, from its Range header
+func requestedRangeStart(r *http.Request, length int64) int64 {
+	rangeHeader := r.Header.Get("Range")
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return 0
+	}
+
+	// Only the first range matters for buffering
+	spec, _, _ := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), ",")
+	startString, endString, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0
+	}
+
+	if startString == "" {
+		// Suffix range: the last N bytes
+		suffix, err := strconv.ParseInt(endString, 10, 64)
+		if err != nil || suffix >= length {
+			return 0
+		}
+		return length - suffix
+	}
+
+	start, err := strconv.ParseInt(startString, 10, 64)
+	if err != nil || start < 0 || start >= length {
+		return 0
+	}
+	return start
+}
+
+// Number of bytes from offset that are downloaded without a gap
+func contiguousBytesAvailable(file *torrent.File, offset int64) int64 {
+	var pieceStart, available int64
+	for _, piece := range file.State() {
+		pieceEnd := pieceStart + piece.Bytes
+		if pieceEnd > offset {
+			if !piece.Complete {
+				break
+			}
+			if pieceStart < offset {
+				available += pieceEnd - offset
+			} else {
+				available += piece.Bytes
+			}
+		}
+		pieceStart = pieceEnd
+	}
+	return available
+}
+
+// Report the download state of a file so players can show buffering
+func setBufferingHeaders(w http.ResponseWriter, file *torrent.File, offset int64) {
+	percent := 0.0
+	if file.Length() > 0 {
+		percent = float64(file.BytesCompleted()) / float64(file.Length()) * 100
+	}
+
+	w.Header().Set(downloadPercentHeader, fmt.Sprintf("%.2f", percent))
+	w.Header().Set(contiguousBytesHeader, strconv.FormatInt(contiguousBytesAvailable(file, offset), 10))
+	w.Header().Set("Access-Control-Expose-Headers", downloadPercentHeader+", "+contiguousBytesHeader+", Retry-After")
+}
+
+// Wait until the piece holding offset is downloaded, or the deadline passes
+func waitForFileData(ctx context.Context, file *torrent.File, offset int64, deadline time.Duration) bool {
+	if offset >= file.Length() {
+		return true
+	}
+
+	t := file.Torrent()
+	pieceIndex := int((file.Offset() + offset) / t.Info().PieceLength)
+	if t.PieceState(pieceIndex).Complete {
+		return true
+	}
+
+	// Make sure the piece is fetched even if we give up and the player retries later
+	t.Piece(pieceIndex).SetPriority(torrent.PiecePriorityNow)
+
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if t.PieceState(pieceIndex).Complete {
+				return true
+			}
+		}
+	}
+}
+
+// In nowait mode (?nowait=1), respond 503 with Retry-After instead of
+// blocking when the first requested piece isn't available before the
+// deadline (?deadline=<ms>). Returns false if a response was written.
+func awaitStreamData(w http.ResponseWriter, r *http.Request, file *torrent.File, offset int64) bool {
+	query := r.URL.Query()
+	if noWait, _ := strconv.ParseBool(query.Get("nowait")); !noWait {
+		return true
+	}
+
+	deadline := defaultNoWaitDeadline
+	if deadlineParam := query.Get("deadline"); deadlineParam != "" {
+		deadlineMs, err := strconv.Atoi(deadlineParam)
+		if err != nil || deadlineMs < 0 {
+			http.Error(w, "Invalid deadline", http.StatusBadRequest)
+			return false
+		}
+		deadline = time.Duration(deadlineMs) * time.Millisecond
+	}
+
+	if !waitForFileData(r.Context(), file, offset, deadline) {
+		retryAfter := int(deadline.Round(time.Second) / time.Second)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Requested data is still buffering", http.StatusServiceUnavailable)
+		return false
+	}
+
+	// Refresh the headers now that more data is available
+	setBufferingHeaders(w, file, offset)
+	return true
+}
+
+// Whether a playback position is close enough to the end to prepare the next episode
+func nearEndOfPlayback(position, duration float64) bool {
+	if duration <= 0 {
+		return false
+	}
+	return position >= duration*nextEpisodeFraction || duration-position <= nextEpisodeRemaining.Seconds()
+}
+
+// Start downloading the first pieces of the video after fileIndex, so the
+// player can move on to it without stalling
+func prebufferNextEpisode(t *torrent.Torrent, fileIndex int) {
+	files := t.Files()
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.DisplayPath()
+	}
+	next, ok := nextVideoFiles(paths)[fileIndex]
+	if !ok {
+		return
+	}
+
+	file := files[next]
+	length := min(file.Length(), nextEpisodePrebufferBytes)
+	if length == 0 {
+		return
+	}
+	pieceLength := t.Info().PieceLength
+	first := int(file.Offset() / pieceLength)
+	last := int((file.Offset() + length - 1) / pieceLength)
+
+	// Progress is reported every few seconds, so skip pieces that are already on their way
+	if state := t.PieceState(first); state.Complete || state.Priority >= torrent.PiecePriorityHigh {
+		return
+	}
+	for i := first; i <= last; i++ {
+		t.Piece(i).SetPriority(torrent.PiecePriorityHigh)
+	}
+	log.Printf("Prebuffering next episode: %s (pieces %d-%d)", file.DisplayPath(), first, last)
+}
+
+// Find a file by its DisplayPath, or by its full path including the torrent name
+func findTorrentFile(t *torrent.Torrent, filePath string) (*torrent.File, bool) {
+	filePath = strings.Trim(filePath, "/")
+	for _, file := range t.Files() {
+		if file.DisplayPath() == filePath || file.Path() == filePath {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// Path-based stream URL for a file, with every path segment escaped
+func torrentFileURL(sessionID, displayPath string) string {
+	segments := strings.Split(displayPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("/api/v1/torrent/%s/file/%s", sessionID, strings.Join(segments, "/"))
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	textunicode "golang.org/x/text/encoding/unicode"
+)
+
+// Matches a cue timing line in either SRT (00:00:20,000) or VTT (00:20.000) form
+var cueTimingPattern = regexp.MustCompile(`^(\s*)((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})(\s*-->\s*)((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})(.*)$`)
+
+// Encodings we try when a subtitle file is not valid UTF-8
+var subtitleEncodingCandidates = []struct {
+	name     string
+	encoding encoding.Encoding
+}{
+	{"windows-1251", charmap.Windows1251},
+	{"gbk", simplifiedchinese.GBK},
+	{"shift_jis", japanese.ShiftJIS},
+	{"windows-1252", charmap.Windows1252},
+}
+
+// Serve a subtitle file as UTF-8, optionally re-timed and converted to VTT
+func serveSubtitle(w http.ResponseWriter, r *http.Request, file *torrent.File, extension string) {
+	query := r.URL.Query()
+
+	var offset time.Duration
+	if offsetParam := query.Get("offset"); offsetParam != "" {
+		offsetMs, err := strconv.ParseInt(offsetParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid subtitle offset", http.StatusBadRequest)
+			return
+		}
+		offset = time.Duration(offsetMs) * time.Millisecond
+	}
+
+	// Read the subtitle file with size limit
+	reader := file.NewReader()
+	defer reader.Close()
+	// Wrap with limiting reader to prevent memory issues (10MB max)
+	subtitleBytes, err := io.ReadAll(io.LimitReader(reader, 10*1024*1024))
+	if err != nil {
+		http.Error(w, "Failed to read subtitle file", http.StatusInternalServerError)
+		return
+	}
+
+	subtitleBytes, err = decodeSubtitle(subtitleBytes, query.Get("encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if offset != 0 {
+		subtitleBytes = shiftSubtitleCues(subtitleBytes, offset, extension == ".vtt")
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
+	if extension == ".srt" && query.Get("format") == "vtt" {
+		// Convert from SRT to VTT
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Write(convertSRTtoVTT(subtitleBytes))
+		return
+	}
+
+	if extension == ".vtt" {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(subtitleBytes)
+}
+
+// Convert subtitle bytes to UTF-8. An empty or "auto" encoding name means detect it.
+func decodeSubtitle(data []byte, encodingName string) ([]byte, error) {
+	encodingName = strings.ToLower(strings.TrimSpace(encodingName))
+
+	if encodingName != "" && encodingName != "auto" {
+		enc, err := htmlindex.Get(encodingName)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported subtitle encoding: %s", encodingName)
+		}
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode subtitle as %s: %v", encodingName, err)
+		}
+		return bytes.TrimPrefix(decoded, []byte("\ufeff")), nil
+	}
+
+	// Byte order marks are the only reliable signal, so check them first
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:], nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		decoded, err := textunicode.UTF16(textunicode.LittleEndian, textunicode.ExpectBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode UTF-16 subtitle: %v", err)
+		}
+		return decoded, nil
+	}
+
+	if utf8.Valid(data) {
+		return data, nil
+	}
+
+	return decodeSubtitleGuess(data), nil
+}
+
+// Pick the candidate encoding whose output looks most like real text
+func decodeSubtitleGuess(data []byte) []byte {
+	var best []byte
+	bestScore := -1
+
+	for _, candidate := range subtitleEncodingCandidates {
+		decoded, err := candidate.encoding.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+		score := scoreDecodedSubtitle(decoded, candidate.name)
+		if score > bestScore {
+			best = decoded
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		// Windows-1252 maps every byte, so this is the last resort
+		best, _ = charmap.Windows1252.NewDecoder().Bytes(data)
+	}
+	return best
+}
+
+// The most frequent Russian letters, which dominate real Windows-1251 text
+const commonCyrillicLetters = "оеаинтсрвлкм"
+
+// Score decoded text by how much of its non-ASCII content looks like the
+// script the encoding is used for. Mixing that script with ASCII letters
+// inside a word, stray symbols and decode errors all count against it.
+func scoreDecodedSubtitle(decoded []byte, encodingName string) int {
+	score := 0
+	var prev rune
+	for _, r := range string(decoded) {
+		previous := prev
+		prev = r
+		isASCIILetter := r < utf8.RuneSelf && unicode.IsLetter(r)
+		prevASCIILetter := previous < utf8.RuneSelf && unicode.IsLetter(previous)
+		if r < utf8.RuneSelf {
+			if isASCIILetter && isNonLatinLetter(previous) {
+				score -= 3
+			}
+			continue
+		}
+
+		if prevASCIILetter && isNonLatinLetter(r) {
+			score -= 3
+			continue
+		}
+
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Co, r):
+			score -= 10
+		case r >= 0xFF61 && r <= 0xFF9F:
+			// Half-width katakana almost always means the wrong multi-byte decoder
+			score -= 2
+		case unicode.Is(unicode.Cyrillic, r):
+			if encodingName != "windows-1251" {
+				break
+			}
+			switch {
+			case strings.ContainsRune(commonCyrillicLetters, r):
+				score += 2
+			case r >= 'а' && r <= 'я':
+				score++
+			case r >= 'А' && r <= 'Я' && unicode.IsLower(previous) && unicode.Is(unicode.Cyrillic, previous):
+				// Capitals in the middle of a lowercase word
+				score -= 2
+			case r < 'А' || r > 'я':
+				score -= 2
+			}
+		case unicode.Is(unicode.Han, r):
+			switch encodingName {
+			case "gbk":
+				score += 2
+			case "shift_jis":
+				score++
+			}
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			if encodingName == "shift_jis" {
+				score += 3
+			}
+		case unicode.Is(unicode.Latin, r):
+			if encodingName == "windows-1252" {
+				score++
+			}
+		case encodingName == "windows-1251" || encodingName == "windows-1252":
+			// Symbols are rare in dialogue
+			score -= 2
+		}
+	}
+	return score
+}
+
+// Letters that should not share a word with ASCII letters
+func isNonLatinLetter(r rune) bool {
+	return unicode.In(r, unicode.Cyrillic, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// Move every cue in an SRT or VTT file by offset, clamping at zero
+func shiftSubtitleCues(data []byte, offset time.Duration, vtt bool) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		match := cueTimingPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		start, errStart := parseCueTimestamp(match[2])
+		end, errEnd := parseCueTimestamp(match[4])
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+		lines[i] = match[1] + formatCueTimestamp(start+offset, vtt) + match[3] +
+			formatCueTimestamp(end+offset, vtt) + match[5]
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Parse "HH:MM:SS,mmm", "HH:MM:SS.mmm" or "MM:SS.mmm"
+func parseCueTimestamp(timestamp string) (time.Duration, error) {
+	timestamp = strings.Replace(timestamp, ",", ".", 1)
+	clock, fraction, _ := strings.Cut(timestamp, ".")
+
+	fields := strings.Split(clock, ":")
+	if len(fields) == 2 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("invalid cue timestamp: %s", timestamp)
+	}
+
+	var total time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cue timestamp: %s", timestamp)
+		}
+		total += time.Duration(value) * units[i]
+	}
+
+	// Pad so that ".5" means 500ms rather than 5ms
+	for len(fraction) < 3 {
+		fraction += "0"
+	}
+	millis, err := strconv.Atoi(fraction)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cue timestamp: %s", timestamp)
+	}
+	return total + time.Duration(millis)*time.Millisecond, nil
+}
+
+func formatCueTimestamp(d time.Duration, vtt bool) string {
+	if d < 0 {
+		d = 0
+	}
+	separator := ","
+	if vtt {
+		separator = "."
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// Name suffixes that describe a subtitle track rather than its language
+var subtitleFlags = map[string]bool{
+	"forced": true,
+	"sdh":    true,
+	"cc":     true,
+	"full":   true,
+	"hi":     true,
+}
+
+var subtitleNameSeparators = regexp.MustCompile(`[\s._\-()\[\]]+`)
+
+// Parse the language from names like "Movie.en.srt", "Movie.eng.forced.srt" or "Subs/2_English.srt"
+func parseSubtitleLanguage(name string) (mediaLanguage, bool) {
+	stem := fileStem(name)
+
+	// Codes are ordinary words too ("it", "no", "may"), so they only count as
+	// dot suffixes right before the extension, or as the whole name
+	suffixes := strings.Split(stem, ".")
+	for i := len(suffixes) - 1; i >= 0 && i >= len(suffixes)-3; i-- {
+		// "hi" is usually "hearing impaired", so only treat it as Hindi on its own
+		if subtitleFlags[strings.ToLower(suffixes[i])] && i > 0 {
+			continue
+		}
+		if lang, ok := lookupLanguageSuffix(suffixes[i]); ok {
+			return lang, true
+		}
+		break
+	}
+
+	// Language names can appear anywhere in the last few tokens
+	tokens := subtitleNameSeparators.Split(stem, -1)
+	first := len(tokens) - 3
+	if first < 0 {
+		first = 0
+	}
+	for i := len(tokens) - 1; i >= first; i-- {
+		if len(tokens[i]) <= 3 {
+			continue
+		}
+		if lang, ok := lookupLanguage(tokens[i]); ok {
+			return lang, true
+		}
+	}
+	return mediaLanguage{}, false
+}
+
+// Look up a language code used as a name suffix. Title-cased words like
+// "What.Is.It" are part of the name rather than a tag, so codes must be all
+// lower or all upper case.
+func lookupLanguageSuffix(suffix string) (mediaLanguage, bool) {
+	if suffix != strings.ToLower(suffix) && suffix != strings.ToUpper(suffix) {
+		return mediaLanguage{}, false
+	}
+	return lookupLanguage(suffix)
+}
+
+// Subtitle stem with trailing language and flag suffixes removed, so that
+// "Show.S01E02.en.forced.srt" compares equal to "Show.S01E02.mkv"
+func subtitleBaseStem(name string) string {
+	stem := fileStem(name)
+	for {
+		dot := strings.LastIndex(stem, ".")
+		if dot < 0 {
+			return stem
+		}
+		suffix := stem[dot+1:]
+		if _, isLanguage := lookupLanguageSuffix(suffix); !isLanguage && !subtitleFlags[strings.ToLower(suffix)] {
+			return stem
+		}
+		stem = stem[:dot]
+	}
+}
+
+// Group subtitle files with the video they belong to, by basename, folder
+// layout (e.g. "Subs/<video name>/2_English.srt") and SxxEyy numbering.
+// The result maps a video's index in paths to the indexes of its subtitles.
+func matchSubtitlesToVideos(paths []string) map[int][]int {
+	var videos, subtitles []int
+	for i, p := range paths {
+		if isVideoFile(p) {
+			videos = append(videos, i)
+		} else if isSubtitleFile(p) {
+			subtitles = append(subtitles, i)
+		}
+	}
+
+	matches := make(map[int][]int)
+	if len(videos) == 0 {
+		return matches
+	}
+
+	for _, sub := range subtitles {
+		if video, ok := matchSubtitleToVideo(paths, videos, paths[sub]); ok {
+			matches[video] = append(matches[video], sub)
+		}
+	}
+	return matches
+}
+
+func matchSubtitleToVideo(paths []string, videos []int, subPath string) (int, bool) {
+	subStem := strings.ToLower(subtitleBaseStem(subPath))
+	subDir := path.Dir(subPath)
+
+	// Same name as the video, with or without a language suffix
+	for _, video := range videos {
+		if strings.ToLower(fileStem(paths[video])) == subStem {
+			return video, true
+		}
+	}
+
+	// Subtitles in a folder named after the video
+	for _, video := range videos {
+		videoStem := strings.ToLower(fileStem(paths[video]))
+		for _, dir := range strings.Split(strings.ToLower(subDir), "/") {
+			if dir == videoStem {
+				return video, true
+			}
+		}
+	}
+
+	// Same season and episode numbers
+	if subEpisode, ok := parseEpisode(subPath); ok {
+		candidate, found := -1, 0
+		for _, video := range videos {
+			if videoEpisode, ok := parseEpisode(paths[video]); ok && videoEpisode == subEpisode {
+				candidate = video
+				found++
+			}
+		}
+		if found == 1 {
+			return candidate, true
+		}
+		if found > 1 {
+			return 0, false
+		}
+	}
+
+	// Video name followed by extra tags, e.g. "Movie.2019.1080p.English-SDH.srt"
+	for _, video := range videos {
+		videoStem := strings.ToLower(fileStem(paths[video]))
+		if strings.HasPrefix(subStem, videoStem) {
+			return video, true
+		}
+	}
+
+	// The only video in the subtitle's folder or its parent (e.g. "Subs/English.srt")
+	candidate, found := -1, 0
+	for _, video := range videos {
+		videoDir := path.Dir(paths[video])
+		if videoDir == subDir || videoDir == path.Dir(subDir) {
+			candidate = video
+			found++
+		}
+	}
+	if found == 1 {
+		return candidate, true
+	}
+
+	if len(videos) == 1 {
+		return videos[0], true
+	}
+	return 0, false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A cue header, so fixtures look like the start of a real SRT file
+const testCueHeader = "1\r\n00:00:01,000 --> 00:00:02,000\r\n"
+
+func TestDecodeSubtitle(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		encoding string
+		want     string
+	}{
+		{"utf-8", testCueHeader + "Ça va? Où est le café?", "", testCueHeader + "Ça va? Où est le café?"},
+		{"utf-8 bom", "\xef\xbb\xbf" + testCueHeader + "Hello", "", testCueHeader + "Hello"},
+		{"utf-16le bom", "\xff\xfe\x48\x00\x65\x00\x6c\x00\x6c\x00\x6f\x00\x2c\x00\x20\x00\x77\x00\xf6\x00\x72\x00\x6c\x00\x64\x00", "", "Hello, wörld"},
+		{"utf-16be bom", "\xfe\xff\x00\x48\x00\x65\x00\x6c\x00\x6c\x00\x6f\x00\x2c\x00\x20\x00\x77\x00\xf6\x00\x72\x00\x6c\x00\x64", "", "Hello, wörld"},
+		{"windows-1252", testCueHeader + "\xc7\x61\x20\x76\x61\x3f\x20\x4f\xf9\x20\x65\x73\x74\x20\x6c\x65\x20\x63\x61\x66\xe9\x3f", "", testCueHeader + "Ça va? Où est le café?"},
+		{"windows-1251", testCueHeader + "\xcf\xf0\xe8\xe2\xe5\xf2\x2c\x20\xea\xe0\xea\x20\xe4\xe5\xeb\xe0\x3f\x20\xc2\xf1\xb8\x20\xf5\xee\xf0\xee\xf8\xee\x2e", "", testCueHeader + "Привет, как дела? Всё хорошо."},
+		{"shift_jis", testCueHeader + "\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\x81\x41\x8c\xb3\x8b\x43\x82\xc5\x82\xb7\x82\xa9\x81\x48", "", testCueHeader + "こんにちは、元気ですか？"},
+		{"gbk", testCueHeader + "\xc4\xe3\xba\xc3\xa3\xac\xbd\xf1\xcc\xec\xcc\xec\xc6\xf8\xba\xdc\xba\xc3\xa1\xa3", "", testCueHeader + "你好，今天天气很好。"},
+		// An explicit encoding is used as-is, even where detection would guess differently
+		{"explicit koi8-r", "\xf0\xd2\xc9\xd7\xc5\xd4", "KOI8-R", "Привет"},
+		{"explicit windows-1251", "\xcf\xf0\xe8\xe2\xe5\xf2", " windows-1251 ", "Привет"},
+		{"explicit utf-8 bom", "\xef\xbb\xbfHello", "utf-8", "Hello"},
+		{"auto", testCueHeader + "\xcf\xf0\xe8\xe2\xe5\xf2", "auto", testCueHeader + "Привет"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSubtitle([]byte(tt.data), tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSubtitleUnknownEncoding(t *testing.T) {
+	for _, name := range []string{"klingon", "utf-9", "latin-99"} {
+		if _, err := decodeSubtitle([]byte("Hello"), name); err == nil {
+			t.Errorf("encoding %q was accepted", name)
+		}
+	}
+}
+
+func TestScoreDecodedSubtitle(t *testing.T) {
+	type decoding struct {
+		text     string
+		encoding string
+	}
+	tests := []struct {
+		name          string
+		better, worse decoding
+	}{
+		// Windows-1251 bytes decoded as Windows-1252
+		{"cyrillic", decoding{"Привет, как дела?", "windows-1251"}, decoding{"Ïðèâåò, êàê äåëà?", "windows-1252"}},
+		// Windows-1252 bytes decoded as Windows-1251 mix scripts within words
+		{"mixed words", decoding{"Café déjà vu", "windows-1252"}, decoding{"Cafй dйjа vu", "windows-1251"}},
+		{"half-width kana", decoding{"こんにちは", "shift_jis"}, decoding{"ｺﾝﾆﾁﾊ", "shift_jis"}},
+		{"replacement characters", decoding{"こんにちは", "shift_jis"}, decoding{"こ\ufffd\ufffdにちは", "shift_jis"}},
+	}
+	for _, tt := range tests {
+		better := scoreDecodedSubtitle([]byte(tt.better.text), tt.better.encoding)
+		worse := scoreDecodedSubtitle([]byte(tt.worse.text), tt.worse.encoding)
+		if better <= worse {
+			t.Errorf("%s: %q scored %d, not above %q with %d", tt.name, tt.better.text, better, tt.worse.text, worse)
+		}
+	}
+}
+
+func TestParseSubtitleLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Movie.en.srt", "en"},
+		{"Movie.it.srt", "it"},
+		{"Movie.eng.forced.srt", "en"},
+		{"Movie.pt.SDH.srt", "pt"},
+		{"Movie.EN.srt", "en"},
+		{"Subs/2_English.srt", "en"},
+		{"Movie.2019.1080p.English-SDH.srt", "en"},
+		{"Subs/Movie/deutsch.srt", "de"},
+		{"en.srt", "en"},
+		{"hi.srt", "hi"},
+		{"Movie.hi.srt", ""},
+		{"What.Is.It.srt", ""},
+		{"No.Country.for.Old.Men.srt", ""},
+		{"Be.Kind.Rewind.srt", ""},
+		{"Movie to watch.srt", ""},
+		{"Movie.2019.1080p.srt", ""},
+	}
+	for _, tt := range tests {
+		lang, ok := parseSubtitleLanguage(tt.name)
+		if ok != (tt.want != "") || lang.Code != tt.want {
+			t.Errorf("parseSubtitleLanguage(%q) = %q, %v; want %q", tt.name, lang.Code, ok, tt.want)
+		}
+	}
+}
+
+func TestSubtitleBaseStem(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Show.S01E02.en.forced.srt", "Show.S01E02"},
+		{"Show.S01E02.eng.srt", "Show.S01E02"},
+		{"What.Is.It.srt", "What.Is.It"},
+		{"Movie.srt", "Movie"},
+	}
+	for _, tt := range tests {
+		if got := subtitleBaseStem(tt.name); got != tt.want {
+			t.Errorf("subtitleBaseStem(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShiftSubtitleCues(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset time.Duration
+		vtt    bool
+		want   string
+	}{
+		{
+			name:   "srt forward",
+			input:  "1\n00:00:01,000 --> 00:00:02,500\nHello\n",
+			offset: 1500 * time.Millisecond,
+			want:   "1\n00:00:02,500 --> 00:00:04,000\nHello\n",
+		},
+		{
+			name:   "srt to vtt",
+			input:  "1\r\n00:59:59,900 --> 01:00:00,100\r\nHello\r\n",
+			offset: 200 * time.Millisecond,
+			vtt:    true,
+			want:   "1\r\n01:00:00.100 --> 01:00:00.300\r\nHello\r\n",
+		},
+		{
+			name:   "vtt short timestamps and settings",
+			input:  "WEBVTT\n\n00:05.000 --> 00:07.000 align:start\nHi\n",
+			offset: -2 * time.Second,
+			vtt:    true,
+			want:   "WEBVTT\n\n00:00:03.000 --> 00:00:05.000 align:start\nHi\n",
+		},
+		{
+			name:   "clamped at zero",
+			input:  "00:00:01.000 --> 00:00:03.000\nHi\n",
+			offset: -2 * time.Second,
+			vtt:    true,
+			want:   "00:00:00.000 --> 00:00:01.000\nHi\n",
+		},
+		{
+			name:   "text untouched",
+			input:  "Not a cue --> really\n",
+			offset: time.Second,
+			want:   "Not a cue --> really\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(shiftSubtitleCues([]byte(tt.input), tt.offset, tt.vtt)); got != tt.want {
+				t.Errorf("shiftSubtitleCues() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A standalone Torznab (or Newznab) endpoint, as configured in settings
+type TorznabSettings struct {
+	Enabled bool   `json:"enabled"`
+	Name    string `json:"name"`
+	URL     string `json:"url"` // the API endpoint, e.g. http://tracker.local/api
+	APIKey  string `json:"apiKey"`
+}
+
+// Any Torznab-compatible API, queried directly
+type TorznabIndexer struct {
+	Title  string
+	URL    string
+	APIKey string
+	// Optional, defaults to the proxy-aware client
+	Client *http.Client
+}
+
+func newTorznabIndexer(settings TorznabSettings) *TorznabIndexer {
+	return &TorznabIndexer{Title: settings.Name, URL: settings.URL, APIKey: settings.APIKey}
+}
+
+// The configured name, or the endpoint's host when there is none
+func (t *TorznabIndexer) Name() string {
+	if t.Title != "" {
+		return t.Title
+	}
+	if u, err := url.Parse(t.URL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "Torznab"
+}
+
+func (t *TorznabIndexer) get(ctx context.Context, params url.Values) ([]byte, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s URL: %s", t.Name(), t.URL)
+	}
+
+	// Keep any parameters already in the configured URL
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if t.APIKey != "" {
+		query.Set("apikey", t.APIKey)
+	}
+	u.RawQuery = query.Encode()
+
+	body, err := fetchIndexerBody(ctx, t.Client, t.Name(), u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors come back as <error code="100" description="..."/> with a 200 status
+	var apiError struct {
+		XMLName     xml.Name `xml:"error"`
+		Code        string   `xml:"code,attr"`
+		Description string   `xml:"description,attr"`
+	}
+	if xml.Unmarshal(body, &apiError) == nil {
+		return nil, fmt.Errorf("%s error %s: %s", t.Name(), apiError.Code, apiError.Description)
+	}
+	return body, nil
+}
+
+func (t *TorznabIndexer) Test(ctx context.Context) ([]byte, error) {
+	return t.get(ctx, url.Values{"t": {"caps"}})
+}
+
+// An RSS item from a Torznab feed. Attribute tags have no namespace so both
+// torznab:attr and newznab:attr match.
+type torznabItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	PubDate   string `xml:"pubDate"`
+	Size      int64  `xml:"size"`
+	Category  string `xml:"category"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"enclosure"`
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+}
+
+func (item torznabItem) attr(name string) string {
+	for _, attr := range item.Attrs {
+		if strings.EqualFold(attr.Name, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (t *TorznabIndexer) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	params := url.Values{"t": {query.Type}}
+	if query.Query != "" {
+		params.Set("q", query.Query)
+	}
+	if len(query.Categories) > 0 {
+		params.Set("cat", query.categoryList())
+	}
+	if query.Season > 0 {
+		params.Set("season", strconv.Itoa(query.Season))
+	}
+	if query.Episode > 0 {
+		params.Set("ep", strconv.Itoa(query.Episode))
+	}
+	if query.IMDbID != "" {
+		// Torznab IMDb IDs go without the tt prefix
+		params.Set("imdbid", strings.TrimPrefix(query.IMDbID, "tt"))
+	}
+	if query.TMDbID > 0 {
+		params.Set("tmdbid", strconv.Itoa(query.TMDbID))
+	}
+	if query.Year > 0 {
+		params.Set("year", strconv.Itoa(query.Year))
+	}
+
+	body, err := t.get(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed struct {
+		Items []torznabItem `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response", t.Name())
+	}
+
+	var results []SearchResult
+	for _, item := range feed.Items {
+		// The magnet may be an attribute, the link itself or the enclosure
+		magnetURL := item.attr("magneturl")
+		downloadURL := item.Link
+		if downloadURL == "" {
+			downloadURL = item.Enclosure.URL
+		}
+		if magnetURL == "" && strings.HasPrefix(downloadURL, "magnet:") {
+			magnetURL = downloadURL
+		}
+
+		size := item.Size
+		if attrSize, err := strconv.ParseInt(item.attr("size"), 10, 64); err == nil {
+			size = attrSize
+		} else if size == 0 {
+			size = item.Enclosure.Length
+		}
+
+		result, ok := newSearchResult(t.Name(), item.Title, magnetURL, downloadURL, size)
+		if !ok {
+			continue
+		}
+		if infoHash := item.attr("infohash"); infoHash != "" {
+			result.InfoHash = strings.ToLower(infoHash)
+		}
+
+		// Torznab's peers count includes seeders
+		result.Seeders, _ = strconv.Atoi(item.attr("seeders"))
+		if peers, err := strconv.Atoi(item.attr("peers")); err == nil && peers >= result.Seeders {
+			result.Leechers = peers - result.Seeders
+		}
+		result.Indexer = t.Name()
+		result.PublishDate = item.PubDate
+		result.Category = item.Category
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Torznab Settings Save Handler
+func saveTorznabSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings struct {
+		TorznabIndexers []TorznabSettings `json:"torznabIndexers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	// Names identify indexers in search errors, so they have to be unique
+	names := make(map[string]bool)
+	for _, settings := range newSettings.TorznabIndexers {
+		if u, err := url.Parse(settings.URL); err != nil || u.Host == "" {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid Torznab URL: " + settings.URL})
+			return
+		}
+		name := newTorznabIndexer(settings).Name()
+		if names[name] {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Duplicate Torznab indexer name: " + name})
+			return
+		}
+		names[name] = true
+	}
+
+	settingsMutex.Lock()
+	currentSettings.TorznabIndexers = newSettings.TorznabIndexers
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	// Cached results may be from the old indexers
+	clearSearchCache()
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Torznab settings saved successfully"})
+}
+
+// Test Torznab Connection Handler
+func testTorznabConnection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	var settings TorznabSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	serveIndexerTest(w, r, newTorznabIndexer(settings), settings.URL != "")
+}
+
+// Search one enabled Torznab indexer: POST /api/v1/torznab/search?name=<name>&q=<query>
+func searchFromTorznab(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+
+	settingsMutex.RLock()
+	var indexer *TorznabIndexer
+	for _, settings := range currentSettings.TorznabIndexers {
+		if !settings.Enabled {
+			continue
+		}
+		candidate := newTorznabIndexer(settings)
+		if name == "" || candidate.Name() == name {
+			indexer = candidate
+			break
+		}
+	}
+	settingsMutex.RUnlock()
+
+	if indexer == nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "No enabled Torznab indexer named " + name})
+		return
+	}
+	serveIndexerSearch(w, r, indexer, indexer.URL != "")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// An ffmpeg encoding preset for browsers that can't play the source codecs
+type TranscodeProfile struct {
+	Name         string `json:"name"`
+	MaxHeight    int    `json:"maxHeight"`
+	VideoCodec   string `json:"videoCodec"`
+	VideoBitrate string `json:"videoBitrate"`
+	Preset       string `json:"preset"`
+	AudioCodec   string `json:"audioCodec"`
+	AudioBitrate string `json:"audioBitrate"`
+}
+
+type TranscodingSettings struct {
+	MaxTranscodes     int                `json:"maxTranscodes"`
+	TranscodeProfiles []TranscodeProfile `json:"transcodeProfiles"`
+}
+
+const defaultMaxTranscodes = 2
+
+// H.264/AAC plays in every browser
+var defaultTranscodeProfiles = []TranscodeProfile{
+	{Name: "480p", MaxHeight: 480, VideoCodec: "libx264", VideoBitrate: "1500k", Preset: "veryfast", AudioCodec: "aac", AudioBitrate: "128k"},
+	{Name: "720p", MaxHeight: 720, VideoCodec: "libx264", VideoBitrate: "3M", Preset: "veryfast", AudioCodec: "aac", AudioBitrate: "160k"},
+	{Name: "1080p", MaxHeight: 1080, VideoCodec: "libx264", VideoBitrate: "6M", Preset: "veryfast", AudioCodec: "aac", AudioBitrate: "192k"},
+}
+
+var (
+	activeTranscodes int
+	transcodeMutex   sync.Mutex
+)
+
+// Find a configured transcoding profile by name, falling back to the defaults
+func findTranscodeProfile(name string) (TranscodeProfile, bool) {
+	settingsMutex.RLock()
+	profiles := currentSettings.TranscodeProfiles
+	settingsMutex.RUnlock()
+
+	if len(profiles) == 0 {
+		profiles = defaultTranscodeProfiles
+	}
+	for _, profile := range profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile, true
+		}
+	}
+	return TranscodeProfile{}, false
+}
+
+// Take one of the limited transcoding slots, since every transcode keeps CPU
+// cores busy. Returns false if all slots are in use.
+func acquireTranscodeSlot() (func(), bool) {
+	settingsMutex.RLock()
+	maxTranscodes := currentSettings.MaxTranscodes
+	settingsMutex.RUnlock()
+	if maxTranscodes <= 0 {
+		maxTranscodes = defaultMaxTranscodes
+	}
+
+	transcodeMutex.Lock()
+	defer transcodeMutex.Unlock()
+	if activeTranscodes >= maxTranscodes {
+		return nil, false
+	}
+	activeTranscodes++
+
+	return func() {
+		transcodeMutex.Lock()
+		activeTranscodes--
+		transcodeMutex.Unlock()
+	}, true
+}
+
+// ffmpeg encoding options for a profile. Scaling never upscales, and 10-bit
+// sources are converted to 8-bit 4:2:0, the only format browsers decode.
+func (p TranscodeProfile) ffmpegArgs() []string {
+	filter := "format=yuv420p"
+	if p.MaxHeight > 0 {
+		filter = fmt.Sprintf("scale=-2:'min(%d,ih)',%s", p.MaxHeight, filter)
+	}
+
+	args := []string{"-c:v", p.VideoCodec, "-vf", filter}
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate, "-maxrate", p.VideoBitrate, "-bufsize", p.VideoBitrate)
+	}
+	args = append(args, "-c:a", p.AudioCodec, "-ac", "2")
+	if p.AudioBitrate != "" {
+		args = append(args, "-b:a", p.AudioBitrate)
+	}
+	return args
+}
+
+// Transcoding Settings Save Handler
+func saveTranscodingSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings TranscodingSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	for _, profile := range newSettings.TranscodeProfiles {
+		if profile.Name == "" || profile.VideoCodec == "" || profile.AudioCodec == "" {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Transcode profiles need a name, video codec and audio codec"})
+			return
+		}
+	}
+
+	settingsMutex.Lock()
+	currentSettings.MaxTranscodes = newSettings.MaxTranscodes
+	currentSettings.TranscodeProfiles = newSettings.TranscodeProfiles
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Transcoding settings saved successfully"})
+}